@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type sqsOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Region    string           `short:"r" long:"region" description:"AWS region (default: AWS_REGION or the shared config)"`
+	Queue     string           `short:"q" long:"queue" description:"Name of the queue"`
+	QueueURL  string           `short:"u" long:"queue-url" value-name:"URL" description:"URL of the queue instead of --queue"`
+	Attribute string           `short:"a" long:"attribute" default:"ApproximateNumberOfMessages" choice:"ApproximateNumberOfMessages" choice:"ApproximateNumberOfMessagesNotVisible" choice:"ApproximateNumberOfMessagesDelayed" description:"Attribute of the queue to check"`
+	Warning   pluginutil.Range `short:"w" long:"warning" value-name:"RANGE" description:"Number of messages to result in warning status. Nagios range, e.g. 100 or 10:100"`
+	Critical  pluginutil.Range `short:"c" long:"critical" value-name:"RANGE" description:"Number of messages to result in critical status. Nagios range, e.g. 1000 or 10:1000"`
+}
+
+// sqsAPI is the part of the SQS client used by the check
+type sqsAPI interface {
+	GetQueueUrl(*sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error)
+	GetQueueAttributes(*sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error)
+}
+
+func main() {
+	pluginutil.Main("SQS", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&sqsOpts{}, args)
+}
+
+// newClient creates a client with the credentials of the default chain, the
+// environment variables, the shared credentials file or the instance profile
+func (opts *sqsOpts) newClient() (sqsAPI, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	return sqs.New(sess, opts.config()), nil
+}
+
+// config is the configuration of the client, whose HTTP requests to the API
+// time out after the timeout
+func (opts *sqsOpts) config() *aws.Config {
+	cfg := aws.NewConfig().WithHTTPClient(&http.Client{Timeout: opts.TimeoutDuration()})
+	if opts.Region != "" {
+		cfg = cfg.WithRegion(opts.Region)
+	}
+	return cfg
+}
+
+func (opts *sqsOpts) Run() *checkers.Checker {
+	if (opts.Queue == "") == (opts.QueueURL == "") {
+		return checkers.Unknown("either --queue or --queue-url is required")
+	}
+	client, err := opts.newClient()
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	return opts.check(client)
+}
+
+func (opts *sqsOpts) check(client sqsAPI) *checkers.Checker {
+	n, err := opts.queueSize(client)
+	if err != nil {
+		return pluginutil.Failure(checkers.Unknown(err.Error()))
+	}
+	chkSt := checkers.OK
+	if opts.Warning.Alert(float64(n)) {
+		chkSt = checkers.WARNING
+	}
+	if opts.Critical.Alert(float64(n)) {
+		chkSt = checkers.CRITICAL
+	}
+	name := opts.Queue
+	if name == "" {
+		name = opts.QueueURL
+	}
+	return checkers.NewChecker(chkSt, fmt.Sprintf("%s of %s is %d", opts.Attribute, name, n))
+}
+
+func (opts *sqsOpts) queueSize(client sqsAPI) (int64, error) {
+	queueURL := opts.QueueURL
+	if queueURL == "" {
+		res, err := client.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(opts.Queue)})
+		if err != nil {
+			return 0, err
+		}
+		queueURL = aws.StringValue(res.QueueUrl)
+	}
+	res, err := client.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		AttributeNames: []*string{aws.String(opts.Attribute)},
+		QueueUrl:       aws.String(queueURL),
+	})
+	if err != nil {
+		return 0, err
+	}
+	v, ok := res.Attributes[opts.Attribute]
+	if !ok {
+		return 0, errors.New("no " + opts.Attribute + " in the attributes of the queue")
+	}
+	return strconv.ParseInt(aws.StringValue(v), 10, 64)
+}
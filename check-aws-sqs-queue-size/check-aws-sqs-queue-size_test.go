@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSQS struct {
+	queues map[string]map[string]string
+}
+
+func (f *fakeSQS) GetQueueUrl(in *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	url := "https://sqs.ap-northeast-1.amazonaws.com/123456789012/" + aws.StringValue(in.QueueName)
+	if _, ok := f.queues[url]; !ok {
+		return nil, errors.New("AWS.SimpleQueueService.NonExistentQueue: The specified queue does not exist")
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(url)}, nil
+}
+
+func (f *fakeSQS) GetQueueAttributes(in *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	attrs := map[string]*string{}
+	for _, name := range in.AttributeNames {
+		if v, ok := f.queues[aws.StringValue(in.QueueUrl)][aws.StringValue(name)]; ok {
+			attrs[aws.StringValue(name)] = aws.String(v)
+		}
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: attrs}, nil
+}
+
+func TestCheck(t *testing.T) {
+	client := &fakeSQS{queues: map[string]map[string]string{
+		"https://sqs.ap-northeast-1.amazonaws.com/123456789012/jobs": {
+			"ApproximateNumberOfMessages":           "150",
+			"ApproximateNumberOfMessagesNotVisible": "3",
+		},
+	}}
+	parse := func(args ...string) *sqsOpts {
+		opts := &sqsOpts{}
+		err := pluginutil.Parse(opts, args)
+		assert.Nil(t, err, "something went wrong")
+		return opts
+	}
+
+	testWarning := func() {
+		ckr := parse("-q", "jobs", "-w", "100", "-c", "1000").check(client)
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		assert.Equal(t, "ApproximateNumberOfMessages of jobs is 150", ckr.Message, "something went wrong")
+	}
+	testWarning()
+
+	testQueueURL := func() {
+		ckr := parse("-u", "https://sqs.ap-northeast-1.amazonaws.com/123456789012/jobs", "-a", "ApproximateNumberOfMessagesNotVisible", "-c", "1:").check(client)
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "ApproximateNumberOfMessagesNotVisible of https://sqs.ap-northeast-1.amazonaws.com/123456789012/jobs is 3", ckr.Message, "something went wrong")
+	}
+	testQueueURL()
+
+	testNoAttribute := func() {
+		ckr := parse("-q", "jobs", "-a", "ApproximateNumberOfMessagesDelayed").check(client)
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testNoAttribute()
+
+	testNoQueue := func() {
+		ckr := parse("-q", "missing").check(client)
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "AWS.SimpleQueueService.NonExistentQueue: The specified queue does not exist", ckr.Message, "something went wrong")
+	}
+	testNoQueue()
+
+	testNoOptions := func() {
+		ckr := parse().Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "either --queue or --queue-url is required", ckr.Message, "something went wrong")
+	}
+	testNoOptions()
+}
+
+func TestConfig(t *testing.T) {
+	opts := &sqsOpts{}
+	err := pluginutil.Parse(opts, []string{"-q", "jobs", "-r", "ap-northeast-1", "-t", "3"})
+	assert.Nil(t, err, "something went wrong")
+	cfg := opts.config()
+	assert.Equal(t, "ap-northeast-1", aws.StringValue(cfg.Region), "something went wrong")
+	assert.Equal(t, 3*time.Second, cfg.HTTPClient.Timeout, "something went wrong")
+}
@@ -4,34 +4,28 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 	"io/ioutil"
-	"os"
 	"time"
 )
 
 type certOpts struct {
+	pluginutil.Options
 	CertFile string `short:"f" long:"file" required:"true" description:"cert file name"`
 	Crit     int64  `short:"c" long:"critical" default:"14" description:"The critical threshold in days before expiry"`
 	Warn     int64  `short:"w" long:"warning" default:"30" description:"The threshold in days before expiry"`
 }
 
 func main() {
-	ckr := checkCertExpiration()
-	ckr.Name = "CERT Expiry"
-	ckr.Exit()
+	pluginutil.Main("CERT Expiry", run)
 }
 
-func checkCertExpiration() *checkers.Checker {
-	opts := certOpts{}
-	psr := flags.NewParser(&opts, flags.Default)
-	_, err := psr.Parse()
-	if err != nil {
-		psr.WriteHelp(os.Stdout)
-		os.Exit(1)
-	}
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&certOpts{}, args)
+}
 
+func (opts *certOpts) Run() *checkers.Checker {
 	cfByte, err := ioutil.ReadFile(opts.CertFile)
 	if err != nil {
 		return checkers.Critical(err.Error())
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type certStoreOpts struct {
+	pluginutil.Options
+	File     string   `short:"f" long:"file" required:"true" description:"Java keystore (JKS) or PKCS#12 file"`
+	Password string   `short:"P" long:"password" description:"Password of the store. Required for PKCS#12, and verifies the integrity of JKS"`
+	Alias    []string `short:"a" long:"alias" description:"Alias which should exist in the store (multiple -a options are allowed)"`
+	Crit     int64    `short:"c" long:"critical" default:"14" description:"The critical threshold in days before expiry"`
+	Warn     int64    `short:"w" long:"warning" default:"30" description:"The threshold in days before expiry"`
+}
+
+func main() {
+	pluginutil.Main("CERT Store", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&certStoreOpts{}, args)
+}
+
+func (opts *certStoreOpts) readStore() ([]storeEntry, error) {
+	data, err := ioutil.ReadFile(opts.File)
+	if err != nil {
+		return nil, err
+	}
+	if isJKS(data) {
+		return readJKS(data, opts.Password)
+	}
+	return readPKCS12(data, opts.Password)
+}
+
+func (opts *certStoreOpts) Run() *checkers.Checker {
+	entries, err := opts.readStore()
+	if err != nil {
+		return checkers.Critical(err.Error())
+	}
+
+	aliases := make(map[string]bool)
+	for _, e := range entries {
+		aliases[e.alias] = true
+	}
+	for _, alias := range opts.Alias {
+		if !aliases[alias] {
+			return checkers.Critical(fmt.Sprintf("alias %s is not found in %s", alias, opts.File))
+		}
+	}
+
+	// the nearest expiry among all certificates including intermediates
+	var nearest *storeEntry
+	var notAfter time.Time
+	for i, e := range entries {
+		for _, cert := range e.certs {
+			if nearest == nil || cert.NotAfter.Before(notAfter) {
+				nearest, notAfter = &entries[i], cert.NotAfter
+			}
+		}
+	}
+	if nearest == nil {
+		return checkers.Unknown(fmt.Sprintf("no certificate in %s", opts.File))
+	}
+
+	daysRemaining := int64(notAfter.Sub(time.Now().UTC()).Hours() / 24)
+	checkSt := checkers.OK
+	msg := fmt.Sprintf("%d days remaining (alias %s expires at %s), %d entries", daysRemaining, nearest.alias, notAfter.UTC().Format("2006-01-02"), len(entries))
+	if daysRemaining < opts.Crit {
+		checkSt = checkers.CRITICAL
+	} else if daysRemaining < opts.Warn {
+		checkSt = checkers.WARNING
+	}
+	return checkers.NewChecker(checkSt, msg)
+}
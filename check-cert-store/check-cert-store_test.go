@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+func createCert(t *testing.T, cn string, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+type jksEntry struct {
+	alias string
+	key   bool
+	certs [][]byte
+}
+
+// writeJKS writes a version 2 keystore as keytool does
+func writeJKS(entries []jksEntry, password string) []byte {
+	var buf bytes.Buffer
+	w := func(v interface{}) { binary.Write(&buf, binary.BigEndian, v) }
+	utf := func(s string) {
+		w(uint16(len(s)))
+		buf.WriteString(s)
+	}
+	w(uint32(jksMagic))
+	w(uint32(2))
+	w(uint32(len(entries)))
+	for _, e := range entries {
+		if e.key {
+			w(uint32(1))
+		} else {
+			w(uint32(2))
+		}
+		utf(e.alias)
+		w(time.Now().UnixNano() / int64(time.Millisecond))
+		if e.key {
+			// encrypted private key, which is not read
+			w(uint32(4))
+			buf.WriteString("dumm")
+			w(uint32(len(e.certs)))
+		}
+		for _, der := range e.certs {
+			utf("X.509")
+			w(uint32(len(der)))
+			buf.Write(der)
+		}
+	}
+	buf.Write(jksDigest(buf.Bytes(), password))
+	return buf.Bytes()
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-cert-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	jks := writeJKS([]jksEntry{
+		{alias: "server", key: true, certs: [][]byte{
+			createCert(t, "server", now.AddDate(0, 0, 100)),
+			createCert(t, "intermediate", now.AddDate(0, 0, 20)),
+		}},
+		{alias: "root", certs: [][]byte{createCert(t, "root", now.AddDate(1, 0, 0))}},
+	}, "changeit")
+	file := filepath.Join(dir, "keystore.jks")
+	if err := ioutil.WriteFile(file, jks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testJKS := func() {
+		ckr := run([]string{"-f", file, "-a", "server", "-a", "root"})
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		assert.Regexp(t, `^(19|20) days remaining \(alias server expires at \d{4}-\d{2}-\d{2}\), 2 entries$`, ckr.Message, "something went wrong")
+	}
+	testJKS()
+
+	testAlias := func() {
+		ckr := run([]string{"-f", file, "-a", "tomcat"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "alias tomcat is not found in "+file, ckr.Message, "something went wrong")
+	}
+	testAlias()
+
+	testPassword := func() {
+		ckr := run([]string{"-f", file, "-P", "changeit", "-w", "10", "-c", "5"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+
+		ckr = run([]string{"-f", file, "-P", "wrong"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "jks: keystore was tampered with, or password was incorrect", ckr.Message, "something went wrong")
+	}
+	testPassword()
+
+	testPKCS12 := func() {
+		// the certificate expires in 2126
+		ckr := run([]string{"-f", "testdata/server.p12", "-P", "changeit", "-a", "server"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\(alias server expires at 2126-09-21\), 1 entries$`, ckr.Message, "something went wrong")
+
+		ckr = run([]string{"-f", "testdata/server.p12", "-P", "wrong"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testPKCS12()
+
+	testPKCS12AES := func() {
+		// exported by OpenSSL 3 with PBES2/AES-256-CBC and SHA-256 MAC
+		ckr := run([]string{"-f", "testdata/server-aes.p12", "-P", "changeit", "-a", "server"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\(alias server expires at 2126-09-21\), 1 entries$`, ckr.Message, "something went wrong")
+
+		ckr = run([]string{"-f", "testdata/server-aes.p12", "-P", "wrong"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testPKCS12AES()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// storeEntry is an entry of a keystore with its certificate chain
+type storeEntry struct {
+	alias string
+	certs []*x509.Certificate
+}
+
+const jksMagic = 0xfeedfeed
+
+func isJKS(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == jksMagic
+}
+
+// jksReader reads the big-endian fields of a Java keystore
+type jksReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *jksReader) uint16() uint16 {
+	var v uint16
+	r.read(&v)
+	return v
+}
+
+func (r *jksReader) uint32() uint32 {
+	var v uint32
+	r.read(&v)
+	return v
+}
+
+func (r *jksReader) read(v interface{}) {
+	if r.err == nil {
+		r.err = binary.Read(r.r, binary.BigEndian, v)
+	}
+}
+
+func (r *jksReader) bytes(n uint32) []byte {
+	if r.err != nil {
+		return nil
+	}
+	// avoid allocating a huge buffer for a broken file
+	var buf bytes.Buffer
+	if _, r.err = io.CopyN(&buf, r.r, int64(n)); r.err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// utf reads a string written by DataOutputStream.writeUTF. Aliases are
+// ASCII in practice, for which modified UTF-8 is the same as UTF-8.
+func (r *jksReader) utf() string {
+	return string(r.bytes(uint32(r.uint16())))
+}
+
+func (r *jksReader) cert(version uint32) *x509.Certificate {
+	if version == 2 {
+		// certificate type, which is always X.509
+		r.utf()
+	}
+	der := r.bytes(r.uint32())
+	if r.err != nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		r.err = err
+	}
+	return cert
+}
+
+// readJKS reads the certificates of a Java keystore. The integrity of the
+// keystore is verified with the password unless it is empty. Private keys
+// are not decrypted.
+func readJKS(data []byte, password string) ([]storeEntry, error) {
+	if len(data) < 20 {
+		return nil, errors.New("jks: too short")
+	}
+	body, digest := data[:len(data)-20], data[len(data)-20:]
+	if password != "" && !bytes.Equal(jksDigest(body, password), digest) {
+		return nil, errors.New("jks: keystore was tampered with, or password was incorrect")
+	}
+
+	r := &jksReader{r: bytes.NewReader(body)}
+	if r.uint32() != jksMagic {
+		return nil, errors.New("jks: invalid magic number")
+	}
+	version := r.uint32()
+	if r.err == nil && version != 1 && version != 2 {
+		return nil, fmt.Errorf("jks: unsupported version: %d", version)
+	}
+	count := r.uint32()
+	var entries []storeEntry
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		tag := r.uint32()
+		entry := storeEntry{alias: r.utf()}
+		var timestamp int64
+		r.read(&timestamp)
+		switch tag {
+		case 1: // private key with the certificate chain
+			r.bytes(r.uint32())
+			for n := r.uint32(); n > 0 && r.err == nil; n-- {
+				entry.certs = append(entry.certs, r.cert(version))
+			}
+		case 2: // trusted certificate
+			entry.certs = append(entry.certs, r.cert(version))
+		default:
+			return nil, fmt.Errorf("jks: unsupported entry: %d", tag)
+		}
+		entries = append(entries, entry)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("jks: %s", r.err)
+	}
+	return entries, nil
+}
+
+// jksDigest is the SHA-1 digest of the password in UTF-16, the salt
+// "Mighty Aphrodite" and the keystore
+func jksDigest(body []byte, password string) []byte {
+	h := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// readPKCS12 reads the certificates of a PKCS#12 bundle grouped by their
+// friendly names, which Java uses as aliases. Both the legacy 3DES bundles
+// and the AES ones of OpenSSL 3 and JDK 12+ are supported.
+func readPKCS12(data []byte, password string) ([]storeEntry, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, err
+	}
+	var entries []storeEntry
+	index := make(map[string]int)
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		alias := block.Headers["friendlyName"]
+		i, ok := index[alias]
+		if !ok {
+			i = len(entries)
+			index[alias] = i
+			entries = append(entries, storeEntry{alias: alias})
+		}
+		entries[i].certs = append(entries[i].certs, cert)
+	}
+	return entries, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type diskOpts struct {
+	pluginutil.Options
+	Warning       string   `short:"w" long:"warning" default:"20%" value-name:"N%|SIZE" description:"Free space to result in warning status. Percent (e.g. 20%) or size (e.g. 500MB, 10GB)"`
+	Critical      string   `short:"c" long:"critical" default:"10%" value-name:"N%|SIZE" description:"Free space to result in critical status. Percent (e.g. 10%) or size (e.g. 500MB, 10GB)"`
+	InodeWarning  string   `short:"W" long:"inode-warning" value-name:"N%" description:"Free inodes to result in warning status"`
+	InodeCritical string   `short:"K" long:"inode-critical" value-name:"N%" description:"Free inodes to result in critical status"`
+	Path          []string `short:"p" long:"path" description:"Mount point or a path on the filesystem to check (multiple -p options are allowed, default: all mounted filesystems)"`
+	ExcludeType   []string `short:"X" long:"exclude-type" value-name:"TYPE" description:"Filesystem type to exclude when checking all mounted filesystems (multiple -X options are allowed)"`
+	ReadOnly      bool     `long:"include-readonly" description:"Check also read-only filesystems, e.g. squashfs and iso9660, when checking all mounted filesystems"`
+	warning       *threshold
+	critical      *threshold
+	inodeWarning  *threshold
+	inodeCritical *threshold
+}
+
+func main() {
+	pluginutil.Main("Disk", run)
+}
+
+func parseArgs(args []string) (*diskOpts, error) {
+	opts := &diskOpts{}
+	err := pluginutil.Parse(opts, args)
+	return opts, err
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&diskOpts{}, args)
+}
+
+// threshold is the minimum free space in percent or in bytes
+type threshold struct {
+	percent   float64
+	bytes     uint64
+	isPercent bool
+}
+
+var units = []struct {
+	suffix string
+	bytes  uint64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseThreshold parses a threshold. A number without unit is in MB as
+// Nagios' check_disk.
+func parseThreshold(s string, allowSize bool) (*threshold, error) {
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid threshold: %s", s)
+		}
+		return &threshold{percent: p, isPercent: true}, nil
+	}
+	if !allowSize {
+		return nil, fmt.Errorf("invalid threshold: %s (must be percent)", s)
+	}
+	num, mul := strings.ToUpper(s), uint64(1<<20)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mul = strings.TrimSuffix(num, u.suffix), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid threshold: %s", s)
+	}
+	return &threshold{bytes: uint64(n * float64(mul))}, nil
+}
+
+// exceeded reports whether free of total is less than the threshold
+func (t *threshold) exceeded(free, total uint64) bool {
+	if t == nil {
+		return false
+	}
+	if t.isPercent {
+		return total > 0 && float64(free)*100/float64(total) < t.percent
+	}
+	return free < t.bytes
+}
+
+// diskUsage is the usage of a filesystem. Sizes are in bytes.
+type diskUsage struct {
+	path       string
+	total      uint64
+	avail      uint64
+	inodes     uint64
+	inodesFree uint64
+}
+
+func (opts *diskOpts) prepare() error {
+	var err error
+	if opts.warning, err = parseThreshold(opts.Warning, true); err != nil {
+		return err
+	}
+	if opts.critical, err = parseThreshold(opts.Critical, true); err != nil {
+		return err
+	}
+	if opts.InodeWarning != "" {
+		if opts.inodeWarning, err = parseThreshold(opts.InodeWarning, false); err != nil {
+			return err
+		}
+	}
+	if opts.InodeCritical != "" {
+		if opts.inodeCritical, err = parseThreshold(opts.InodeCritical, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (opts *diskOpts) paths() ([]string, error) {
+	if len(opts.Path) > 0 {
+		return opts.Path, nil
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, err
+	}
+	return opts.filterMounts(mounts), nil
+}
+
+// readOnlyTypes are the filesystems which are always full
+var readOnlyTypes = map[string]bool{"squashfs": true, "iso9660": true}
+
+// filterMounts returns the mount points to check. A mount point mounted
+// over is checked once, and so is a device mounted on several mount points
+// by bind mounts.
+func (opts *diskOpts) filterMounts(mounts []mount) []string {
+	var paths []string
+	dirs := make(map[string]bool)
+	devices := make(map[string]bool)
+	for _, m := range mounts {
+		if dirs[m.dir] || devices[m.device] {
+			continue
+		}
+		if (m.readOnly || readOnlyTypes[m.fstype]) && !opts.ReadOnly {
+			continue
+		}
+		excluded := false
+		for _, t := range opts.ExcludeType {
+			if m.fstype == t {
+				excluded = true
+				break
+			}
+		}
+		if excluded {
+			continue
+		}
+		dirs[m.dir] = true
+		// the devices of pseudo filesystems, e.g. tmpfs, are not unique
+		if strings.HasPrefix(m.device, "/") {
+			devices[m.device] = true
+		}
+		paths = append(paths, m.dir)
+	}
+	return paths
+}
+
+func (opts *diskOpts) Run() *checkers.Checker {
+	if err := opts.prepare(); err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	paths, err := opts.paths()
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+
+	chkSt := checkers.OK
+	var msgs []string
+	for _, path := range paths {
+		du, err := statDisk(path)
+		if err != nil {
+			if len(opts.Path) == 0 {
+				// mount points may be inaccessible or gone
+				continue
+			}
+			return checkers.Unknown(err.Error())
+		}
+		if du.total == 0 {
+			// pseudo filesystems
+			continue
+		}
+		st, msg := opts.evaluate(du)
+		if st > chkSt {
+			chkSt = st
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return checkers.Unknown("no filesystem to check")
+	}
+	return checkers.NewChecker(chkSt, strings.Join(msgs, ", "))
+}
+
+func (opts *diskOpts) evaluate(du *diskUsage) (checkers.Status, string) {
+	st := checkers.OK
+	if opts.warning.exceeded(du.avail, du.total) || opts.inodeWarning.exceeded(du.inodesFree, du.inodes) {
+		st = checkers.WARNING
+	}
+	if opts.critical.exceeded(du.avail, du.total) || opts.inodeCritical.exceeded(du.inodesFree, du.inodes) {
+		st = checkers.CRITICAL
+	}
+	msg := fmt.Sprintf("%s %.1f%% free (%s of %s)", du.path, float64(du.avail)*100/float64(du.total), humanize(du.avail), humanize(du.total))
+	if du.inodes > 0 && (opts.inodeWarning != nil || opts.inodeCritical != nil) {
+		msg += fmt.Sprintf(" inodes %.1f%% free", float64(du.inodesFree)*100/float64(du.inodes))
+	}
+	return st, msg
+}
+
+func humanize(bytes uint64) string {
+	for _, u := range units {
+		if bytes >= u.bytes && u.bytes > 1 {
+			return fmt.Sprintf("%.1f %s", float64(bytes)/float64(u.bytes), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
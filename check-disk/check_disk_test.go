@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThreshold(t *testing.T) {
+	th, err := parseThreshold("20%", true)
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.Equal(t, &threshold{percent: 20, isPercent: true}, th, "percent")
+
+	th, err = parseThreshold("10GB", true)
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.Equal(t, &threshold{bytes: 10 << 30}, th, "size with unit")
+
+	th, err = parseThreshold("500", true)
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.Equal(t, &threshold{bytes: 500 << 20}, th, "size without unit is in MB")
+
+	_, err = parseThreshold("500MB", false)
+	assert.NotNil(t, err, "size is not allowed for inodes")
+
+	_, err = parseThreshold("120%", true)
+	assert.NotNil(t, err, "percent must be up to 100")
+}
+
+func TestEvaluate(t *testing.T) {
+	du := &diskUsage{path: "/data", total: 100 << 30, avail: 15 << 30, inodes: 1000, inodesFree: 50}
+
+	opts, _ := parseArgs([]string{})
+	opts.prepare()
+	st, msg := opts.evaluate(du)
+	assert.Equal(t, checkers.WARNING, st, "15% free is under 20%")
+	assert.Equal(t, "/data 15.0% free (15.0 GB of 100.0 GB)", msg, "something went wrong")
+
+	opts, _ = parseArgs([]string{"-w", "10GB", "-c", "5GB", "-K", "10%"})
+	opts.prepare()
+	st, msg = opts.evaluate(du)
+	assert.Equal(t, checkers.CRITICAL, st, "5% free inodes is under 10%")
+	assert.Equal(t, "/data 15.0% free (15.0 GB of 100.0 GB) inodes 5.0% free", msg, "something went wrong")
+
+	opts, _ = parseArgs([]string{"-w", "10%", "-c", "5%"})
+	opts.prepare()
+	st, _ = opts.evaluate(du)
+	assert.Equal(t, checkers.OK, st, "15% free is enough")
+}
+
+func TestFilterMounts(t *testing.T) {
+	mounts := []mount{
+		{device: "/dev/sda1", dir: "/", fstype: "ext4"},
+		{device: "tmpfs", dir: "/dev/shm", fstype: "tmpfs"},
+		{device: "tmpfs", dir: "/run", fstype: "tmpfs"},
+		{device: "shm", dir: "/dev/shm", fstype: "tmpfs"},
+		{device: "/dev/sda1", dir: "/var/lib/docker", fstype: "ext4"},
+		{device: "/dev/loop0", dir: "/snap/core/1", fstype: "squashfs", readOnly: true},
+		{device: "/dev/sr0", dir: "/media/cdrom", fstype: "iso9660"},
+		{device: "/dev/sdb1", dir: "/backup", fstype: "xfs", readOnly: true},
+	}
+
+	opts, _ := parseArgs([]string{})
+	assert.Equal(t, []string{"/", "/dev/shm", "/run"}, opts.filterMounts(mounts), "something went wrong")
+
+	opts, _ = parseArgs([]string{"-X", "tmpfs"})
+	assert.Equal(t, []string{"/"}, opts.filterMounts(mounts), "something went wrong")
+
+	opts, _ = parseArgs([]string{"--include-readonly"})
+	assert.Equal(t, []string{"/", "/dev/shm", "/run", "/snap/core/1", "/media/cdrom", "/backup"}, opts.filterMounts(mounts), "something went wrong")
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ckr := run([]string{"-p", dir, "-w", "0%", "-c", "0%"})
+	assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	assert.Regexp(t, `% free \(`, ckr.Message, "something went wrong")
+
+	ckr = run([]string{"-p", dir, "-w", "0%", "-c", "100%"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+
+	ckr = run([]string{"-p", dir + "/nothing"})
+	assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+
+	ckr = run([]string{"-w", "0%", "-c", "0%", "-X", "tmpfs"})
+	assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+}
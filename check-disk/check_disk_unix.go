@@ -0,0 +1,62 @@
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+type mount struct {
+	device   string
+	dir      string
+	fstype   string
+	readOnly bool
+}
+
+func listMounts() ([]mount, error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mounted filesystems (specify --path): %s", err)
+	}
+	defer f.Close()
+	var mounts []mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		// spaces in mount points are escaped as \040
+		dir := strings.Replace(fields[1], `\040`, " ", -1)
+		m := mount{device: fields[0], dir: dir, fstype: fields[2]}
+		for _, o := range strings.Split(fields[3], ",") {
+			if o == "ro" {
+				m.readOnly = true
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+func statDisk(path string) (*diskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, fmt.Errorf("statfs %s: %s", path, err)
+	}
+	bsize := uint64(st.Bsize)
+	used := (uint64(st.Blocks) - uint64(st.Bfree)) * bsize
+	avail := uint64(st.Bavail) * bsize
+	return &diskUsage{
+		path: path,
+		// blocks reserved for root are excluded as df does
+		total:      used + avail,
+		avail:      avail,
+		inodes:     uint64(st.Files),
+		inodesFree: uint64(st.Ffree),
+	}, nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import "errors"
+
+type mount struct {
+	device   string
+	dir      string
+	fstype   string
+	readOnly bool
+}
+
+func listMounts() ([]mount, error) {
+	return nil, errors.New("check-disk is not supported on Windows")
+}
+
+func statDisk(path string) (*diskUsage, error) {
+	return nil, errors.New("check-disk is not supported on Windows")
+}
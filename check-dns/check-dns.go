@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"github.com/miekg/dns"
+)
+
+type dnsOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Host                string   `short:"H" long:"host" required:"true" description:"Host name to query"`
+	Server              string   `short:"s" long:"server" description:"DNS server to query (default: the first nameserver in /etc/resolv.conf)"`
+	Port                int      `short:"p" long:"port" default:"53" description:"Port number of the DNS server"`
+	QueryType           string   `short:"q" long:"querytype" default:"A" description:"Record type to query. e.g. A, AAAA, CNAME, MX, NS, TXT"`
+	ExpectedIP          []string `short:"a" long:"expected-ip" description:"IP Address expected in the answer (multiple -a options are allowed)"`
+	ExpectAuthoritative bool     `short:"A" long:"expect-authoritative" description:"Expect the server to answer authoritatively"`
+	TCP                 bool     `long:"tcp" description:"Use TCP instead of UDP"`
+	Warning             float64  `short:"w" long:"warning" description:"Response time to result in warning status (seconds)"`
+	Critical            float64  `short:"c" long:"critical" description:"Response time to result in critical status (seconds)"`
+}
+
+func main() {
+	pluginutil.Main("DNS", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&dnsOpts{}, args)
+}
+
+func (opts *dnsOpts) server() (string, error) {
+	if opts.Server != "" {
+		return opts.Server, nil
+	}
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	if len(conf.Servers) == 0 {
+		return "", fmt.Errorf("no nameserver found in /etc/resolv.conf")
+	}
+	return conf.Servers[0], nil
+}
+
+func (opts *dnsOpts) Run() *checkers.Checker {
+	qtype, ok := dns.StringToType[strings.ToUpper(opts.QueryType)]
+	if !ok {
+		return checkers.Unknown(fmt.Sprintf("unknown query type: %s", opts.QueryType))
+	}
+	var expectedIPs []net.IP
+	for _, s := range opts.ExpectedIP {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return checkers.Unknown(fmt.Sprintf("invalid expected-ip: %s", s))
+		}
+		expectedIPs = append(expectedIPs, ip)
+	}
+	server, err := opts.server()
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(opts.Host), qtype)
+	c := &dns.Client{
+		Timeout: opts.TimeoutDuration(),
+	}
+	if opts.TCP {
+		c.Net = "tcp"
+	}
+	r, rtt, err := c.Exchange(m, net.JoinHostPort(server, strconv.Itoa(opts.Port)))
+	if err != nil {
+		return pluginutil.Failure(checkers.Critical(err.Error()))
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return checkers.Critical(fmt.Sprintf("%s returns %s from %s", opts.Host, dns.RcodeToString[r.Rcode], server))
+	}
+
+	var answers []string
+	var ips []net.IP
+	for _, rr := range r.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A)
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA)
+		}
+		if rr.Header().Rrtype == qtype {
+			answers = append(answers, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+	if len(answers) == 0 {
+		return checkers.Critical(fmt.Sprintf("%s has no %s record on %s", opts.Host, dns.TypeToString[qtype], server))
+	}
+
+	chkSt := checkers.OK
+	msg := fmt.Sprintf("%.3f seconds response time. %s returns %s", rtt.Seconds(), opts.Host, strings.Join(answers, ", "))
+	for _, expected := range expectedIPs {
+		found := false
+		for _, ip := range ips {
+			if ip.Equal(expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			chkSt = checkers.CRITICAL
+			msg += fmt.Sprintf("; %s is not in the answer", expected)
+		}
+	}
+	if opts.ExpectAuthoritative && !r.Authoritative {
+		chkSt = checkers.CRITICAL
+		msg += fmt.Sprintf("; %s is not authoritative", server)
+	}
+	if chkSt == checkers.OK {
+		if opts.Critical > 0 && rtt.Seconds() > opts.Critical {
+			chkSt = checkers.CRITICAL
+		} else if opts.Warning > 0 && rtt.Seconds() > opts.Warning {
+			chkSt = checkers.WARNING
+		}
+	}
+	return checkers.NewChecker(chkSt, msg)
+}
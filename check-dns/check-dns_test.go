@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveDNS(t *testing.T, delay time.Duration) (string, string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			time.Sleep(delay)
+			m := new(dns.Msg)
+			m.SetReply(req)
+			q := req.Question[0]
+			switch {
+			case q.Name == "www.example.com." && q.Qtype == dns.TypeA:
+				m.Authoritative = true
+				rr, _ := dns.NewRR("www.example.com. 300 IN A 192.0.2.1")
+				m.Answer = append(m.Answer, rr)
+				rr, _ = dns.NewRR("www.example.com. 300 IN A 192.0.2.2")
+				m.Answer = append(m.Answer, rr)
+			case q.Name == "example.com." && q.Qtype == dns.TypeMX:
+				rr, _ := dns.NewRR("example.com. 300 IN MX 10 mail.example.com.")
+				m.Answer = append(m.Answer, rr)
+			case q.Name == "www.example.com.":
+			default:
+				m.Rcode = dns.RcodeNameError
+			}
+			w.WriteMsg(m)
+		}),
+	}
+	go server.ActivateAndServe()
+	<-started
+	host, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	return host, port, func() { server.Shutdown() }
+}
+
+func TestRun(t *testing.T) {
+	host, port, shutdown := serveDNS(t, 0)
+	defer shutdown()
+
+	testA := func() {
+		ckr := run([]string{"-H", "www.example.com", "-s", host, "-p", port, "-a", "192.0.2.2", "-A"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `www\.example\.com returns 192\.0\.2\.1, 192\.0\.2\.2$`, ckr.Message, "Unexpected response")
+	}
+	testA()
+
+	testMX := func() {
+		ckr := run([]string{"-H", "example.com", "-s", host, "-p", port, "-q", "mx"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `example\.com returns 10 mail\.example\.com\.$`, ckr.Message, "Unexpected response")
+	}
+	testMX()
+
+	testUnexpectedIP := func() {
+		ckr := run([]string{"-H", "www.example.com", "-s", host, "-p", port, "-a", "192.0.2.3"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `; 192\.0\.2\.3 is not in the answer$`, ckr.Message, "Unexpected response")
+	}
+	testUnexpectedIP()
+
+	testNotAuthoritative := func() {
+		ckr := run([]string{"-H", "example.com", "-s", host, "-p", port, "-q", "MX", "-A"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `; 127\.0\.0\.1 is not authoritative$`, ckr.Message, "Unexpected response")
+	}
+	testNotAuthoritative()
+
+	testNoRecord := func() {
+		ckr := run([]string{"-H", "www.example.com", "-s", host, "-p", port, "-q", "AAAA"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "www.example.com has no AAAA record on 127.0.0.1", ckr.Message, "Unexpected response")
+	}
+	testNoRecord()
+
+	testNXDomain := func() {
+		ckr := run([]string{"-H", "nx.example.com", "-s", host, "-p", port})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "nx.example.com returns NXDOMAIN from 127.0.0.1", ckr.Message, "Unexpected response")
+	}
+	testNXDomain()
+
+	testUnknownType := func() {
+		ckr := run([]string{"-H", "www.example.com", "-s", host, "-p", port, "-q", "XYZ"})
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testUnknownType()
+}
+
+func TestResponseTime(t *testing.T) {
+	host, port, shutdown := serveDNS(t, 200*time.Millisecond)
+	defer shutdown()
+
+	ckr := run([]string{"-H", "www.example.com", "-s", host, "-p", port, "-w", "0.1", "-c", "1"})
+	assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+
+	ckr = run([]string{"-H", "www.example.com", "-s", host, "-p", port, "-w", "0.05", "-c", "0.1"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+
+	ckr = run([]string{"-H", "www.example.com", "-s", host, "-p", port, "-t", "0.05"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+}
@@ -4,11 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"time"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 type healthStat struct {
@@ -16,31 +15,30 @@ type healthStat struct {
 	Status      string `json:"status"`
 }
 
-var opts struct {
+type elasticsearchOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
 	Scheme string `short:"s" long:"scheme" default:"http" description:"Elasticsearch scheme"`
 	Host   string `short:"H" long:"host" default:"localhost" description:"Elasticsearch host"`
 	Port   int64  `short:"p" long:"port" default:"9200" description:"Elasticsearch port"`
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "Elasticsearch"
-	ckr.Exit()
+	pluginutil.Main("Elasticsearch", run)
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&elasticsearchOpts{}, args)
+}
 
-	client := &http.Client{}
+func (opts *elasticsearchOpts) Run() *checkers.Checker {
+	client := &http.Client{Timeout: opts.TimeoutDuration()}
 	url := fmt.Sprintf("%s://%s:%d/_cluster/health", opts.Scheme, opts.Host, opts.Port)
 
 	stTime := time.Now()
 	resp, err := client.Get(url)
 	if err != nil {
-		return checkers.Critical(err.Error())
+		return pluginutil.Failure(checkers.Critical(err.Error()))
 	}
 	elapsed := time.Since(stTime)
 	defer resp.Body.Close()
@@ -3,16 +3,16 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "FileAge"
-	ckr.Exit()
+	pluginutil.Main("FileAge", run)
 }
 
 type monitor struct {
@@ -57,8 +57,9 @@ func newMonitor(warningAge, warningSize, criticalAge, criticalSize int64) *monit
 	}
 }
 
-var opts struct {
-	File          string `short:"f" long:"file" required:"true" description:"monitor file name"`
+type fileAgeOpts struct {
+	pluginutil.Options
+	File          string `short:"f" long:"file" required:"true" description:"monitor file name, or glob pattern to monitor the newest matching file"`
 	WarningAge    int64  `short:"w" long:"warning-age" default:"240" description:"warning if more old than"`
 	WarningSize   int64  `short:"W" long:"warning-size" description:"warning if file size less than"`
 	CriticalAge   int64  `short:"c" long:"critical-age" default:"600" description:"critical if more old than"`
@@ -67,12 +68,18 @@ var opts struct {
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&fileAgeOpts{}, args)
+}
 
-	stat, err := os.Stat(opts.File)
+func (opts *fileAgeOpts) Run() *checkers.Checker {
+	file := opts.File
+	var stat os.FileInfo
+	var err error
+	if isGlob(file) {
+		file, stat, err = newestFile(file)
+	} else {
+		stat, err = os.Stat(file)
+	}
 	if err != nil {
 		if opts.IgnoreMissing {
 			return checkers.Ok("No such file, but ignore missing is set.")
@@ -96,6 +103,33 @@ func run(args []string) *checkers.Checker {
 		result = checkers.CRITICAL
 	}
 
-	msg := fmt.Sprintf("%s is %d seconds old (%02d:%02d:%02d) and %d bytes.\n", opts.File, age, mtime.Hour(), mtime.Minute(), mtime.Second(), size)
+	msg := fmt.Sprintf("%s is %d seconds old (%02d:%02d:%02d) and %d bytes.\n", file, age, mtime.Hour(), mtime.Minute(), mtime.Second(), size)
 	return checkers.NewChecker(result, msg)
 }
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// newestFile returns the most recently modified file matching the pattern
+func newestFile(pattern string) (string, os.FileInfo, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	var newest string
+	var newestStat os.FileInfo
+	for _, f := range files {
+		stat, err := os.Stat(f)
+		if err != nil || stat.IsDir() {
+			continue
+		}
+		if newestStat == nil || stat.ModTime().After(newestStat.ModTime()) {
+			newest, newestStat = f, stat
+		}
+	}
+	if newestStat == nil {
+		return "", nil, fmt.Errorf("no file matches %s", pattern)
+	}
+	return newest, newestStat, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-file-age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for i, name := range []string{"heartbeat.1", "heartbeat.2", "heartbeat.3"} {
+		f := filepath.Join(dir, name)
+		ioutil.WriteFile(f, []byte("ok"), 0644)
+		// heartbeat.2 is the newest
+		mtime := now.Add(-time.Duration([]int{300, 10, 900}[i]) * time.Second)
+		os.Chtimes(f, mtime, mtime)
+	}
+	os.Mkdir(filepath.Join(dir, "heartbeat.d"), 0755)
+
+	file, _, err := newestFile(filepath.Join(dir, "heartbeat.*"))
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.Equal(t, filepath.Join(dir, "heartbeat.2"), file, "the newest file should be chosen")
+
+	_, _, err = newestFile(filepath.Join(dir, "nothing.*"))
+	assert.NotNil(t, err, "no file matches")
+
+	ckr := run([]string{"-f", filepath.Join(dir, "heartbeat.*"), "-w", "60", "-c", "600"})
+	assert.Equal(t, checkers.OK, ckr.Status, "the newest file is fresh")
+
+	ckr = run([]string{"-f", filepath.Join(dir, "heartbeat.[13]"), "-w", "60", "-c", "600"})
+	assert.Equal(t, checkers.WARNING, ckr.Status, "the newest file is stale")
+
+	ckr = run([]string{"-f", filepath.Join(dir, "heartbeat.[13]"), "-w", "60", "-c", "600", "-C", "10"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "the newest file is too small")
+}
@@ -9,17 +9,16 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "FileSize"
-	ckr.Exit()
+	pluginutil.Main("FileSize", run)
 }
 
-var opts struct {
+type fileSizeOpts struct {
+	pluginutil.Options
 	Base  string `short:"b" long:"base" required:"true" description:"base directory"`
 	Warn  string `short:"w" long:"warning" default:"1K" description:"warning if the size is over"`
 	Crit  string `short:"c" long:"critical" default:"1K" description:"critical if the size is over"`
@@ -83,11 +82,10 @@ func listFiles(base string, depth int) ([]string, error) {
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&fileSizeOpts{}, args)
+}
 
+func (opts *fileSizeOpts) Run() *checkers.Checker {
 	ws, err := sizeValue(opts.Warn)
 	if err != nil {
 		return checkers.NewChecker(checkers.UNKNOWN, err.Error())
@@ -1,68 +1,229 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"crypto/tls"
-	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
-// XXX more options
-var opts struct {
-	URL string `short:"u" long:"url" required:"true" description:"A URL to connect to"`
-	NoCheckCertificate bool `long:"no-check-certificate" description:"Do not check certificate"`
+type checkHTTPOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	URL                string   `short:"u" long:"url" required:"true" description:"A URL to connect to"`
+	Method             string   `short:"m" long:"method" default:"GET" description:"HTTP method"`
+	Headers            []string `short:"H" long:"header" description:"Request header (multiple -H options are allowed). e.g. 'Accept: application/json'"`
+	Body               string   `short:"d" long:"body" description:"Request body"`
+	Statuses           string   `short:"s" long:"status" description:"Comma separated status codes or ranges to expect. Others result in critical status. e.g. 200,301-302"`
+	Regexp             string   `short:"r" long:"regexp" description:"Regexp pattern to expect in response body"`
+	Warning            float64  `short:"w" long:"warning" description:"Response time to result in warning status (seconds)"`
+	Critical           float64  `short:"c" long:"critical" description:"Response time to result in critical status (seconds)"`
+	MaxRedirects       int      `long:"max-redirects" default:"10" description:"Number of redirects to follow. 0 reports the redirect response itself"`
+	Auth               string   `short:"a" long:"auth" value-name:"USER:PASSWORD" description:"Basic authentication credentials"`
+	NoCheckCertificate bool     `long:"no-check-certificate" description:"Do not check certificate"`
+	TLSCAFile          string   `long:"tls-ca-file" value-name:"FILE" description:"CA certificates file to verify the server certificate"`
+	TLSCert            string   `long:"tls-cert" value-name:"FILE" description:"Client certificate file for mutual TLS"`
+	TLSKey             string   `long:"tls-key" value-name:"FILE" description:"Client private key file for mutual TLS"`
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "HTTP"
-	ckr.Exit()
+	pluginutil.Main("HTTP", run)
+}
+
+// statusRange is an inclusive range of status codes
+type statusRange struct {
+	min, max int
+}
+
+func parseStatuses(s string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		bounds := strings.SplitN(r, "-", 2)
+		min, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid status: %s", r)
+		}
+		max := min
+		if len(bounds) == 2 {
+			max, err = strconv.Atoi(bounds[1])
+			if err != nil || max < min {
+				return nil, fmt.Errorf("invalid status: %s", r)
+			}
+		}
+		ranges = append(ranges, statusRange{min, max})
+	}
+	return ranges, nil
+}
+
+func (opts *checkHTTPOpts) tlsConfig() (*tls.Config, error) {
+	conf := &tls.Config{
+		InsecureSkipVerify: opts.NoCheckCertificate,
+	}
+	if opts.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.TLSCAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		if opts.TLSCert == "" || opts.TLSKey == "" {
+			return nil, fmt.Errorf("--tls-cert and --tls-key must be specified together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func (opts *checkHTTPOpts) request() (*http.Request, error) {
+	var body io.Reader
+	if opts.Body != "" {
+		body = strings.NewReader(opts.Body)
+	}
+	req, err := http.NewRequest(opts.Method, opts.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range opts.Headers {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header: %s", h)
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+		} else {
+			req.Header.Add(k, v)
+		}
+	}
+	if opts.Auth != "" {
+		userpass := strings.SplitN(opts.Auth, ":", 2)
+		if len(userpass) != 2 {
+			return nil, fmt.Errorf("invalid auth: must be USER:PASSWORD")
+		}
+		req.SetBasicAuth(userpass[0], userpass[1])
+	}
+	return req, nil
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
+	return pluginutil.Run(&checkHTTPOpts{}, args)
+}
+
+func (opts *checkHTTPOpts) Run() *checkers.Checker {
+	var statuses []statusRange
+	var err error
+	if opts.Statuses != "" {
+		statuses, err = parseStatuses(opts.Statuses)
+		if err != nil {
+			return checkers.Unknown(err.Error())
+		}
+	}
+	var bodyReg *regexp.Regexp
+	if opts.Regexp != "" {
+		bodyReg, err = regexp.Compile(opts.Regexp)
+		if err != nil {
+			return checkers.Unknown(err.Error())
+		}
+	}
+	tlsConfig, err := opts.tlsConfig()
 	if err != nil {
-		os.Exit(1)
+		return checkers.Unknown(err.Error())
+	}
+	req, err := opts.request()
+	if err != nil {
+		return checkers.Unknown(err.Error())
 	}
 
-	tr := &http.Transport {
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: opts.NoCheckCertificate,
+	tr := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	client := &http.Client{
+		Transport: tr,
+		Timeout:   opts.TimeoutDuration(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", opts.MaxRedirects)
+			}
+			return nil
 		},
 	}
-	client := &http.Client{ Transport: tr }
+	if opts.MaxRedirects == 0 {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
 
 	stTime := time.Now()
-	resp, err := client.Get(opts.URL)
+	resp, err := client.Do(req)
 	if err != nil {
-		return checkers.Critical(err.Error())
+		return pluginutil.Failure(checkers.Critical(err.Error()))
 	}
-	elapsed := time.Since(stTime)
 	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return pluginutil.Failure(checkers.Critical(err.Error()))
+	}
+	elapsed := time.Since(stTime)
 
 	cLength := resp.ContentLength
 	if cLength == -1 {
-		byt, _ := ioutil.ReadAll(resp.Body)
-		cLength = int64(len(byt))
+		cLength = int64(len(body))
 	}
 
 	checkSt := checkers.UNKNOWN
-	switch st := resp.StatusCode; true {
-	case st < 400:
-		checkSt = checkers.OK
-	case st < 500:
-		checkSt = checkers.WARNING
-	default:
+	if statuses != nil {
 		checkSt = checkers.CRITICAL
+		for _, r := range statuses {
+			if r.min <= resp.StatusCode && resp.StatusCode <= r.max {
+				checkSt = checkers.OK
+				break
+			}
+		}
+	} else {
+		switch st := resp.StatusCode; true {
+		case st < 400:
+			checkSt = checkers.OK
+		case st < 500:
+			checkSt = checkers.WARNING
+		default:
+			checkSt = checkers.CRITICAL
+		}
 	}
 
 	msg := fmt.Sprintf("%s %s - %d bytes in %f second respons time",
 		resp.Proto, resp.Status, cLength, elapsed.Seconds())
 
+	if bodyReg != nil && !bodyReg.Match(body) {
+		checkSt = checkers.CRITICAL
+		msg += fmt.Sprintf(" - pattern not found: %s", opts.Regexp)
+	}
+	if checkSt == checkers.OK || checkSt == checkers.WARNING {
+		if opts.Critical > 0 && elapsed.Seconds() > opts.Critical {
+			checkSt = checkers.CRITICAL
+		} else if opts.Warning > 0 && elapsed.Seconds() > opts.Warning {
+			checkSt = checkers.WARNING
+		}
+	}
+
 	return checkers.NewChecker(checkSt, msg)
 }
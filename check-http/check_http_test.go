@@ -1,7 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/mackerelio/checkers"
 	"github.com/stretchr/testify/assert"
@@ -18,3 +23,93 @@ func TestNoCheckCertificate(t *testing.T) {
 	assert.Equal(t, ckr.Status, checkers.CRITICAL, "chr.Status should be CRITICAL")
 	assert.Equal(t, ckr.Message, `Get hoge: unsupported protocol scheme ""`, "something went wrong")
 }
+
+func TestStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ckr := run([]string{"-u", ts.URL})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "5xx should be CRITICAL")
+
+	ckr = run([]string{"-u", ts.URL, "-s", "200,500-503"})
+	assert.Equal(t, checkers.OK, ckr.Status, "expected status should be OK")
+
+	ckr = run([]string{"-u", ts.URL, "-s", "200"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "unexpected status should be CRITICAL")
+
+	ckr = run([]string{"-u", ts.URL, "-s", "20x"})
+	assert.Equal(t, checkers.UNKNOWN, ckr.Status, "invalid status should be UNKNOWN")
+	assert.Equal(t, "invalid status: 20x", ckr.Message, "something went wrong")
+}
+
+func TestRegexp(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"green"}`)
+	}))
+	defer ts.Close()
+
+	ckr := run([]string{"-u", ts.URL, "-r", `"status":"green"`})
+	assert.Equal(t, checkers.OK, ckr.Status, "matched body should be OK")
+
+	ckr = run([]string{"-u", ts.URL, "-r", `"status":"red"`})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "unmatched body should be CRITICAL")
+	assert.Regexp(t, `pattern not found: "status":"red"$`, ckr.Message, "something went wrong")
+}
+
+func TestRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.Header.Get("X-Check") != "mackerel" || user != "monitor" || pass != "secret:pass" || string(body) != "ping" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "pong")
+	}))
+	defer ts.Close()
+
+	ckr := run([]string{"-u", ts.URL, "-m", "POST", "-H", "X-Check: mackerel", "-a", "monitor:secret:pass", "-d", "ping", "-r", "pong"})
+	assert.Equal(t, checkers.OK, ckr.Status, "chr.Status should be OK")
+
+	ckr = run([]string{"-u", ts.URL, "-m", "POST", "-d", "ping"})
+	assert.Equal(t, checkers.WARNING, ckr.Status, "chr.Status should be WARNING")
+
+	ckr = run([]string{"-u", ts.URL, "-H", "X-Check"})
+	assert.Equal(t, checkers.UNKNOWN, ckr.Status, "chr.Status should be UNKNOWN")
+}
+
+func TestRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/ok", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	ckr := run([]string{"-u", ts.URL, "-s", "200"})
+	assert.Equal(t, checkers.OK, ckr.Status, "redirect should be followed")
+
+	ckr = run([]string{"-u", ts.URL, "-s", "200", "--max-redirects", "0"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "redirect should not be followed")
+	assert.Regexp(t, `302 Found`, ckr.Message, "something went wrong")
+}
+
+func TestResponseTime(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	ckr := run([]string{"-u", ts.URL, "-w", "0.1", "-c", "1"})
+	assert.Equal(t, checkers.WARNING, ckr.Status, "chr.Status should be WARNING")
+
+	ckr = run([]string{"-u", ts.URL, "-w", "0.05", "-c", "0.1"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "chr.Status should be CRITICAL")
+
+	ckr = run([]string{"-u", ts.URL, "-t", "0.1"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "timeout should be CRITICAL")
+}
@@ -1,26 +1,34 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
-	"os"
-	"time"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 type jmxJolokiaOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
 	HostName  string  `short:"H" long:"host" required:"true" description:"Host name or IP Address"`
 	Port      int     `short:"p" long:"port" default:"8778" description:"Port"`
-	Timeout   int     `short:"t" long:"timeout" default:"10" description:"Seconds before connection times out"`
 	MBean     string  `short:"m" long:"mbean" required:"true" description:"MBean"`
 	Attribute string  `short:"a" long:"attribute" required:"true" description:"Attribute"`
 	InnerPath string  `short:"i" long:"inner-path" description:"InnerPath"`
 	Key       string  `short:"k" long:"key" default:"value" description:"Key"`
 	Warning   float64 `short:"w" long:"warning" description:"Trigger a warning if over a number"`
 	Critical  float64 `short:"c" long:"critical" description:"Trigger a critical if over a number"`
+
+	User               string `short:"u" long:"user" description:"User name for HTTP basic authentication"`
+	Password           string `short:"P" long:"password" description:"Password for HTTP basic authentication"`
+	SSL                bool   `short:"S" long:"ssl" description:"Use HTTPS"`
+	NoCheckCertificate bool   `long:"no-check-certificate" description:"Do not check certificate"`
+	TLSCAFile          string `long:"tls-ca-file" value-name:"FILE" description:"CA certificates file to verify the server certificate"`
 }
 
 type jmxJolokiaResponse struct {
@@ -29,34 +37,57 @@ type jmxJolokiaResponse struct {
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "Jmx-Jolokia"
-	ckr.Exit()
-}
-
-func parseArgs(args []string) (*jmxJolokiaOpts, error) {
-	opts := &jmxJolokiaOpts{}
-	_, err := flags.ParseArgs(opts, args)
-	return opts, err
+	pluginutil.Main("Jmx-Jolokia", run)
 }
 
 func createURL(opts *jmxJolokiaOpts) string {
+	scheme := "http"
+	if opts.SSL {
+		scheme = "https"
+	}
 	if opts.InnerPath == "" {
-		return fmt.Sprintf("http://%s:%d/jolokia/read/%s/%s", opts.HostName, opts.Port, opts.MBean, opts.Attribute)
+		return fmt.Sprintf("%s://%s:%d/jolokia/read/%s/%s", scheme, opts.HostName, opts.Port, opts.MBean, opts.Attribute)
+	}
+	return fmt.Sprintf("%s://%s:%d/jolokia/read/%s/%s/%s", scheme, opts.HostName, opts.Port, opts.MBean, opts.Attribute, opts.InnerPath)
+}
+
+func createClient(opts *jmxJolokiaOpts) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.NoCheckCertificate}
+	if opts.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.TLSCAFile)
+		}
 	}
-	return fmt.Sprintf("http://%s:%d/jolokia/read/%s/%s/%s", opts.HostName, opts.Port, opts.MBean, opts.Attribute, opts.InnerPath)
+	return &http.Client{
+		Timeout:   opts.TimeoutDuration(),
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}, nil
 }
 
 func run(args []string) *checkers.Checker {
-	opts, err := parseArgs(args)
+	return pluginutil.Run(&jmxJolokiaOpts{}, args)
+}
+
+func (opts *jmxJolokiaOpts) Run() *checkers.Checker {
+	client, err := createClient(opts)
 	if err != nil {
-		os.Exit(1)
+		return checkers.Unknown(err.Error())
 	}
-
-	client := &http.Client{Timeout: time.Duration(opts.Timeout) * time.Second}
-	res, err := client.Get(createURL(opts))
+	req, err := http.NewRequest("GET", createURL(opts), nil)
 	if err != nil {
-		return checkers.Critical(err.Error())
+		return checkers.Unknown(err.Error())
+	}
+	if opts.User != "" {
+		req.SetBasicAuth(opts.User, opts.Password)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return pluginutil.Failure(checkers.Critical(err.Error()))
 	}
 
 	defer res.Body.Close()
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "monitor" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/jolokia/read/java.lang:type=Threading/ThreadCount" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"request":{"mbean":"java.lang:type=Threading","attribute":"ThreadCount","type":"read"},"value":42,"timestamp":1609459200,"status":200}`)
+	}))
+	defer ts.Close()
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	testOK := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "-u", "monitor", "-P", "secret",
+			"-m", "java.lang:type=Threading", "-a", "ThreadCount", "-w", "100", "-c", "200"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "java.lang:type=Threading ThreadCount value 42.000000", ckr.Message, "something went wrong")
+	}
+	testOK()
+
+	testWarning := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "-u", "monitor", "-P", "secret",
+			"-m", "java.lang:type=Threading", "-a", "ThreadCount", "-w", "40", "-c", "200"})
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+	}
+	testWarning()
+
+	testUnauthorized := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "-u", "monitor", "-P", "wrong",
+			"-m", "java.lang:type=Threading", "-a", "ThreadCount", "-w", "100", "-c", "200"})
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "failed: http status code 401", ckr.Message, "something went wrong")
+	}
+	testUnauthorized()
+
+	testUnverified := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-S", "-u", "monitor", "-P", "secret",
+			"-m", "java.lang:type=Threading", "-a", "ThreadCount"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testUnverified()
+}
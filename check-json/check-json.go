@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type jsonOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	URL                string   `short:"u" long:"url" description:"A URL to GET. Overrides --scheme, --host, --port and --path"`
+	Scheme             string   `short:"s" long:"scheme" default:"http" description:"Scheme"`
+	Host               string   `short:"H" long:"host" default:"localhost" description:"Host name or IP Address"`
+	Port               int      `short:"p" long:"port" description:"Port number (default: 80 for http, 443 for https)"`
+	Path               string   `long:"path" default:"/" description:"Path of the endpoint"`
+	Key                string   `short:"k" long:"key" required:"true" description:"Key of the field to evaluate. Nested keys and array indexes are joined with dots. e.g. checks.0.status"`
+	OK                 []string `long:"ok" description:"Value to result in OK status (multiple --ok options are allowed)"`
+	Warning            []string `long:"warning" description:"Value to result in warning status (multiple --warning options are allowed)"`
+	Critical           []string `long:"critical" description:"Value to result in critical status (multiple --critical options are allowed)"`
+	NoCheckCertificate bool     `long:"no-check-certificate" description:"Do not check certificate"`
+}
+
+func main() {
+	pluginutil.Main("JSON", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&jsonOpts{}, args)
+}
+
+func (opts *jsonOpts) url() string {
+	if opts.URL != "" {
+		return opts.URL
+	}
+	// host may be a literal IPv6 address with or without brackets
+	host := strings.TrimSuffix(strings.TrimPrefix(opts.Host, "["), "]")
+	u := url.URL{Scheme: opts.Scheme, Host: host, Path: opts.Path}
+	if opts.Port != 0 {
+		u.Host = net.JoinHostPort(host, strconv.Itoa(opts.Port))
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	}
+	return u.String()
+}
+
+// lookup finds the value of the key in the decoded JSON
+func lookup(v interface{}, key string) (interface{}, error) {
+	for _, k := range strings.Split(key, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[k]; !ok {
+				return nil, fmt.Errorf("no such key: %s", key)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("no such key: %s", key)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("no such key: %s", key)
+		}
+	}
+	return v, nil
+}
+
+// format formats the value to compare with the options
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return "null"
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate decides the status of the value. Values not listed result in
+// critical status when --ok is given, or OK otherwise.
+func (opts *jsonOpts) evaluate(value string) checkers.Status {
+	switch {
+	case contains(opts.OK, value):
+		return checkers.OK
+	case contains(opts.Critical, value):
+		return checkers.CRITICAL
+	case contains(opts.Warning, value):
+		return checkers.WARNING
+	case len(opts.OK) > 0:
+		return checkers.CRITICAL
+	}
+	return checkers.OK
+}
+
+func (opts *jsonOpts) Run() *checkers.Checker {
+	if len(opts.OK) == 0 && len(opts.Warning) == 0 && len(opts.Critical) == 0 {
+		return checkers.Unknown("specify at least one of --ok, --warning and --critical")
+	}
+	client := &http.Client{
+		Timeout: opts.TimeoutDuration(),
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.NoCheckCertificate},
+		},
+	}
+
+	stTime := time.Now()
+	resp, err := client.Get(opts.url())
+	if err != nil {
+		return pluginutil.Failure(checkers.Critical(err.Error()))
+	}
+	defer resp.Body.Close()
+
+	// some endpoints reply the health with an error status, e.g. 503 of
+	// Consul, so the body is evaluated regardless of the status
+	var body interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return checkers.Critical(fmt.Sprintf("%s: couldn't decode JSON: %s", resp.Status, err))
+	}
+	elapsed := time.Since(stTime)
+
+	v, err := lookup(body, opts.Key)
+	if err != nil {
+		return checkers.Critical(fmt.Sprintf("%s: %s", resp.Status, err))
+	}
+	value := format(v)
+	msg := fmt.Sprintf("%s: %s - %.3f second response time", opts.Key, value, elapsed.Seconds())
+	return checkers.NewChecker(opts.evaluate(value), msg)
+}
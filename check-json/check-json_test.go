@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	var body interface{} = map[string]interface{}{
+		"status": "green",
+		"checks": []interface{}{
+			map[string]interface{}{"name": "db", "passing": true},
+		},
+		"nodes": float64(3),
+	}
+
+	v, err := lookup(body, "status")
+	assert.Nil(t, err, "err should be nil")
+	assert.Equal(t, "green", format(v), "something went wrong")
+
+	v, err = lookup(body, "checks.0.passing")
+	assert.Nil(t, err, "err should be nil")
+	assert.Equal(t, "true", format(v), "something went wrong")
+
+	v, err = lookup(body, "nodes")
+	assert.Nil(t, err, "err should be nil")
+	assert.Equal(t, "3", format(v), "something went wrong")
+
+	_, err = lookup(body, "checks.1.passing")
+	assert.Error(t, err, "should be an error")
+	_, err = lookup(body, "status.color")
+	assert.Error(t, err, "should be an error")
+}
+
+func TestRun(t *testing.T) {
+	status := "green"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_cluster/health":
+			fmt.Fprintf(w, `{"cluster_name":"es","status":"%s"}`, status)
+		case "/v1/agent/health":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"Status":"critical"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<html><body>Not Found</body></html>")
+		}
+	}))
+	defer ts.Close()
+
+	args := []string{"-u", ts.URL + "/_cluster/health", "-k", "status", "--ok", "green", "--warning", "yellow"}
+
+	testOK := func() {
+		ckr := run(args)
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `^status: green - \d+\.\d{3} second response time$`, ckr.Message, "something went wrong")
+	}
+	testOK()
+
+	testWarning := func() {
+		status = "yellow"
+		ckr := run(args)
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+	}
+	testWarning()
+
+	testNotListed := func() {
+		status = "red"
+		ckr := run(args)
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testNotListed()
+
+	testErrorStatus := func() {
+		ckr := run([]string{"-u", ts.URL + "/v1/agent/health", "-k", "Status", "--warning", "warning", "--critical", "critical"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `^Status: critical - `, ckr.Message, "something went wrong")
+	}
+	testErrorStatus()
+
+	testNotJSON := func() {
+		ckr := run([]string{"-u", ts.URL + "/none", "-k", "status", "--ok", "green"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `^404 Not Found: couldn't decode JSON: `, ckr.Message, "something went wrong")
+	}
+	testNotJSON()
+
+	testNoValues := func() {
+		ckr := run([]string{"-u", ts.URL, "-k", "status"})
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testNoValues()
+}
+
+func TestURL(t *testing.T) {
+	opts := &jsonOpts{Scheme: "http", Host: "localhost", Port: 9200, Path: "/_cluster/health"}
+	assert.Equal(t, "http://localhost:9200/_cluster/health", opts.url(), "something went wrong")
+	opts.Port = 0
+	assert.Equal(t, "http://localhost/_cluster/health", opts.url(), "something went wrong")
+
+	opts = &jsonOpts{Scheme: "http", Host: "::1", Port: 9200, Path: "/_cluster/health"}
+	assert.Equal(t, "http://[::1]:9200/_cluster/health", opts.url(), "something went wrong")
+	opts.Port = 0
+	assert.Equal(t, "http://[::1]/_cluster/health", opts.url(), "something went wrong")
+	opts.Host = "[2001:db8::1]"
+	assert.Equal(t, "http://[2001:db8::1]/_cluster/health", opts.url(), "something went wrong")
+	opts.Port = 8080
+	assert.Equal(t, "http://[2001:db8::1]:8080/_cluster/health", opts.url(), "something went wrong")
+}
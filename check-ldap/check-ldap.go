@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"gopkg.in/ldap.v2"
+)
+
+type ldapOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Host               string  `short:"H" long:"host" default:"localhost" description:"Host name or IP Address"`
+	Port               int     `short:"p" long:"port" description:"Port number (default: 389, or 636 with --ssl)"`
+	SSL                bool    `short:"S" long:"ssl" description:"Use LDAPS"`
+	StartTLS           bool    `short:"T" long:"starttls" description:"Upgrade the connection with StartTLS"`
+	NoCheckCertificate bool    `long:"no-check-certificate" description:"Do not check certificate"`
+	BindDN             string  `short:"D" long:"bind-dn" description:"DN to bind with. Binds anonymously without it"`
+	Password           string  `short:"P" long:"password" description:"Password to bind with"`
+	Base               string  `short:"b" long:"base" description:"Base DN to search. Only binds without it"`
+	Scope              string  `short:"s" long:"scope" default:"base" description:"Scope of the search, base, one or sub"`
+	Filter             string  `short:"f" long:"filter" default:"(objectClass=*)" description:"Filter of the search"`
+	MinEntries         int     `long:"min-entries" description:"Minimum number of entries to be found"`
+	MaxEntries         int     `long:"max-entries" description:"Maximum number of entries to be found"`
+	Warning            float64 `short:"w" long:"warning" description:"Response time to result in warning status (seconds)"`
+	Critical           float64 `short:"c" long:"critical" description:"Response time to result in critical status (seconds)"`
+}
+
+func main() {
+	pluginutil.Main("LDAP", run)
+}
+
+func parseArgs(args []string) (*ldapOpts, error) {
+	opts := &ldapOpts{}
+	err := pluginutil.Parse(opts, args)
+	return opts, err
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&ldapOpts{}, args)
+}
+
+var scopes = map[string]int{
+	"base": ldap.ScopeBaseObject,
+	"one":  ldap.ScopeSingleLevel,
+	"sub":  ldap.ScopeWholeSubtree,
+}
+
+func (opts *ldapOpts) prepare() error {
+	if opts.SSL && opts.StartTLS {
+		return errors.New("--starttls can't be used with --ssl")
+	}
+	if _, ok := scopes[opts.Scope]; !ok {
+		return fmt.Errorf("invalid scope: %s", opts.Scope)
+	}
+	if opts.MaxEntries > 0 && opts.MaxEntries < opts.MinEntries {
+		return errors.New("--max-entries must be greater than or equal to --min-entries")
+	}
+	if opts.Port == 0 {
+		opts.Port = 389
+		if opts.SSL {
+			opts.Port = 636
+		}
+	}
+	return nil
+}
+
+func (opts *ldapOpts) dial(timeout time.Duration, tlsConfig *tls.Config) (*ldap.Conn, error) {
+	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+	d := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if opts.SSL {
+		conn, err = tls.DialWithDialer(d, "tcp", address, tlsConfig)
+	} else {
+		conn, err = d.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := ldap.NewConn(conn, opts.SSL)
+	l.Start()
+	l.SetTimeout(timeout)
+	return l, nil
+}
+
+// ldapConn is the part of *ldap.Conn used by the check
+type ldapConn interface {
+	StartTLS(*tls.Config) error
+	Bind(username, password string) error
+	Search(*ldap.SearchRequest) (*ldap.SearchResult, error)
+}
+
+// search runs the search and returns the number of entries found
+func (opts *ldapOpts) search(l ldapConn) (int, error) {
+	req := ldap.NewSearchRequest(opts.Base, scopes[opts.Scope], ldap.NeverDerefAliases,
+		0, 0, false, opts.Filter, []string{"dn"}, nil)
+	res, err := l.Search(req)
+	if err != nil {
+		return 0, err
+	}
+	return len(res.Entries), nil
+}
+
+func (opts *ldapOpts) Run() *checkers.Checker {
+	if err := opts.prepare(); err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	timeout := opts.TimeoutDuration()
+	tlsConfig := &tls.Config{
+		ServerName:         opts.Host,
+		InsecureSkipVerify: opts.NoCheckCertificate,
+	}
+
+	start := time.Now()
+	l, err := opts.dial(timeout, tlsConfig)
+	if err != nil {
+		return pluginutil.Failure(checkers.Critical(err.Error()))
+	}
+	defer l.Close()
+	return opts.check(l, tlsConfig, start)
+}
+
+// check runs the operations on the connection made at start
+func (opts *ldapOpts) check(l ldapConn, tlsConfig *tls.Config, start time.Time) *checkers.Checker {
+	if opts.StartTLS {
+		if err := l.StartTLS(tlsConfig); err != nil {
+			return checkers.Critical(fmt.Sprintf("StartTLS failed: %s", err))
+		}
+	}
+	// LDAPv3 allows operations without bind, which is anonymous
+	if opts.BindDN != "" {
+		if err := l.Bind(opts.BindDN, opts.Password); err != nil {
+			return checkers.Critical(fmt.Sprintf("bind failed: %s", err))
+		}
+	}
+	msg := ""
+	if opts.Base != "" {
+		n, err := opts.search(l)
+		if err != nil {
+			return checkers.Critical(fmt.Sprintf("search failed: %s", err))
+		}
+		if n < opts.MinEntries || (opts.MaxEntries > 0 && n > opts.MaxEntries) {
+			return checkers.Critical(fmt.Sprintf("%d entries found under %s", n, opts.Base))
+		}
+		msg = fmt.Sprintf("%d entries found, ", n)
+	}
+	elapsed := time.Since(start)
+
+	chkSt := checkers.OK
+	if opts.Critical > 0 && elapsed.Seconds() > opts.Critical {
+		chkSt = checkers.CRITICAL
+	} else if opts.Warning > 0 && elapsed.Seconds() > opts.Warning {
+		chkSt = checkers.WARNING
+	}
+	msg += fmt.Sprintf("%.3f seconds response time on %s port %d", elapsed.Seconds(), opts.Host, opts.Port)
+	return checkers.NewChecker(chkSt, msg)
+}
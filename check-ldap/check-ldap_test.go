@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+func TestPrepare(t *testing.T) {
+	opts, err := parseArgs([]string{"-H", "ldap.example.com"})
+	assert.Nil(t, err, "err should be nil")
+	assert.Nil(t, opts.prepare(), "err should be nil")
+	assert.Equal(t, 389, opts.Port, "something went wrong")
+
+	opts, _ = parseArgs([]string{"-H", "ldap.example.com", "--ssl"})
+	assert.Nil(t, opts.prepare(), "err should be nil")
+	assert.Equal(t, 636, opts.Port, "something went wrong")
+
+	opts, _ = parseArgs([]string{"--ssl", "--starttls"})
+	assert.EqualError(t, opts.prepare(), "--starttls can't be used with --ssl", "something went wrong")
+
+	opts, _ = parseArgs([]string{"-b", "dc=example,dc=com", "-s", "children"})
+	assert.EqualError(t, opts.prepare(), "invalid scope: children", "something went wrong")
+
+	opts, _ = parseArgs([]string{"-b", "dc=example,dc=com", "--min-entries", "3", "--max-entries", "2"})
+	assert.Error(t, opts.prepare(), "should be an error")
+}
+
+// fakeLDAP records the operations and answers them as configured
+type fakeLDAP struct {
+	startTLSErr error
+	bindErr     error
+	searchErr   error
+	entries     int
+	ops         []string
+}
+
+func (f *fakeLDAP) StartTLS(config *tls.Config) error {
+	f.ops = append(f.ops, "StartTLS "+config.ServerName)
+	return f.startTLSErr
+}
+
+func (f *fakeLDAP) Bind(username, password string) error {
+	f.ops = append(f.ops, "Bind "+username+" "+password)
+	return f.bindErr
+}
+
+func (f *fakeLDAP) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	f.ops = append(f.ops, fmt.Sprintf("Search %s %d %s", req.BaseDN, req.Scope, req.Filter))
+	if f.searchErr != nil {
+		return nil, f.searchErr
+	}
+	res := &ldap.SearchResult{}
+	for i := 0; i < f.entries; i++ {
+		res.Entries = append(res.Entries, &ldap.Entry{DN: fmt.Sprintf("uid=user%d,dc=example,dc=com", i)})
+	}
+	return res, nil
+}
+
+func TestCheck(t *testing.T) {
+	check := func(l *fakeLDAP, elapsed time.Duration, args ...string) *checkers.Checker {
+		opts, err := parseArgs(args)
+		assert.Nil(t, err, "err should be nil")
+		assert.Nil(t, opts.prepare(), "err should be nil")
+		return opts.check(l, &tls.Config{ServerName: opts.Host}, time.Now().Add(-elapsed))
+	}
+
+	testAnonymous := func() {
+		l := &fakeLDAP{}
+		ckr := check(l, 0, "-H", "ldap.example.com")
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `^0\.\d{3} seconds response time on ldap\.example\.com port 389$`, ckr.Message, "something went wrong")
+		assert.Empty(t, l.ops, "anonymous connection without search makes no operation")
+	}
+	testAnonymous()
+
+	testBind := func() {
+		l := &fakeLDAP{}
+		ckr := check(l, 0, "-D", "cn=monitor,dc=example,dc=com", "-P", "secret")
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, []string{"Bind cn=monitor,dc=example,dc=com secret"}, l.ops, "something went wrong")
+	}
+	testBind()
+
+	testBindFailed := func() {
+		l := &fakeLDAP{bindErr: errors.New("LDAP Result Code 49 \"Invalid Credentials\"")}
+		ckr := check(l, 0, "-D", "cn=monitor,dc=example,dc=com", "-P", "wrong")
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "bind failed: LDAP Result Code 49 \"Invalid Credentials\"", ckr.Message, "something went wrong")
+	}
+	testBindFailed()
+
+	testStartTLS := func() {
+		l := &fakeLDAP{}
+		ckr := check(l, 0, "-H", "ldap.example.com", "-T", "-D", "cn=monitor,dc=example,dc=com", "-P", "secret")
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, []string{"StartTLS ldap.example.com", "Bind cn=monitor,dc=example,dc=com secret"}, l.ops, "StartTLS should precede bind")
+	}
+	testStartTLS()
+
+	testStartTLSFailed := func() {
+		l := &fakeLDAP{startTLSErr: errors.New("x509: certificate signed by unknown authority")}
+		ckr := check(l, 0, "-T", "-D", "cn=monitor,dc=example,dc=com", "-P", "secret")
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "StartTLS failed: x509: certificate signed by unknown authority", ckr.Message, "something went wrong")
+		assert.Equal(t, []string{"StartTLS localhost"}, l.ops, "should not bind in plaintext")
+	}
+	testStartTLSFailed()
+
+	testSearch := func() {
+		l := &fakeLDAP{entries: 3}
+		ckr := check(l, 0, "-b", "ou=people,dc=example,dc=com", "-s", "one", "-f", "(uid=*)", "--min-entries", "1", "--max-entries", "3")
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `^3 entries found, 0\.\d{3} seconds response time on localhost port 389$`, ckr.Message, "something went wrong")
+		assert.Equal(t, []string{"Search ou=people,dc=example,dc=com 1 (uid=*)"}, l.ops, "something went wrong")
+	}
+	testSearch()
+
+	testSearchFailed := func() {
+		l := &fakeLDAP{searchErr: errors.New("LDAP Result Code 32 \"No Such Object\"")}
+		ckr := check(l, 0, "-b", "ou=people,dc=example,dc=com")
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "search failed: LDAP Result Code 32 \"No Such Object\"", ckr.Message, "something went wrong")
+	}
+	testSearchFailed()
+
+	testTooFewEntries := func() {
+		ckr := check(&fakeLDAP{entries: 0}, 0, "-b", "ou=people,dc=example,dc=com", "--min-entries", "1")
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "0 entries found under ou=people,dc=example,dc=com", ckr.Message, "something went wrong")
+	}
+	testTooFewEntries()
+
+	testTooManyEntries := func() {
+		ckr := check(&fakeLDAP{entries: 4}, 0, "-b", "ou=people,dc=example,dc=com", "--max-entries", "3")
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "4 entries found under ou=people,dc=example,dc=com", ckr.Message, "something went wrong")
+	}
+	testTooManyEntries()
+
+	testNoMaxEntries := func() {
+		ckr := check(&fakeLDAP{entries: 100}, 0, "-b", "ou=people,dc=example,dc=com")
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testNoMaxEntries()
+
+	testWarning := func() {
+		ckr := check(&fakeLDAP{}, 2*time.Second, "-w", "1", "-c", "3")
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		assert.Regexp(t, `^2\.\d{3} seconds response time`, ckr.Message, "something went wrong")
+	}
+	testWarning()
+
+	testCritical := func() {
+		ckr := check(&fakeLDAP{}, 2*time.Second, "-w", "1", "-c", "1.5")
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testCritical()
+}
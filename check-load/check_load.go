@@ -3,18 +3,18 @@ package main
 import (
 	"errors"
 	"fmt"
-	"os"
 	"runtime"
 	"strconv"
 	"strings"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
-var opts struct {
-	WarningThreshold  string `short:"w" long:"warning" required:"true" value-name:"WL1,WL5,WL15" description:"Warning threshold for loadavg1,5,15"`
-	CriticalThreshold string `short:"c" long:"critical" required:"true" value-name:"CL1,CL5,CL15" description:"Critical threshold for loadavg1,5,15"`
+type loadOpts struct {
+	pluginutil.Options
+	WarningThreshold  string `short:"w" long:"warning" required:"true" value-name:"WL1,WL5,WL15" description:"Warning threshold for loadavg1,5,15 (or one threshold for all)"`
+	CriticalThreshold string `short:"c" long:"critical" required:"true" value-name:"CL1,CL5,CL15" description:"Critical threshold for loadavg1,5,15 (or one threshold for all)"`
 	PerCPU            bool   `short:"r" long:"percpu" default:"false" description:"Divide the load averages by cpu count"`
 }
 
@@ -22,6 +22,9 @@ func parseThreshold(str string) ([3]float64, error) {
 	thresholds := [3]float64{0, 0, 0}
 
 	thSt := strings.Split(str, ",")
+	if len(thSt) == 1 {
+		thSt = []string{str, str, str}
+	}
 	if len(thSt) != 3 {
 		return thresholds, errors.New("Threshold must be comma-separated 3 numbers")
 	}
@@ -37,17 +40,14 @@ func parseThreshold(str string) ([3]float64, error) {
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "LOAD"
-	ckr.Exit()
+	pluginutil.Main("LOAD", run)
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&loadOpts{}, args)
+}
 
+func (opts *loadOpts) Run() *checkers.Checker {
 	wload, err := parseThreshold(opts.WarningThreshold)
 	if err != nil {
 		return checkers.Unknown(err.Error())
@@ -62,12 +62,16 @@ func run(args []string) *checkers.Checker {
 		return checkers.Unknown(err.Error())
 	}
 
-	result := checkers.OK
-	for i, load := range loadavgs {
-		if opts.PerCPU {
-			numCPU := runtime.NumCPU()
-			load = load / float64(numCPU)
+	numCPU := runtime.NumCPU()
+	loads := loadavgs
+	if opts.PerCPU {
+		for i := range loads {
+			loads[i] = loads[i] / float64(numCPU)
 		}
+	}
+
+	result := checkers.OK
+	for i, load := range loads {
 		if load > cload[i] {
 			result = checkers.CRITICAL
 			break
@@ -78,5 +82,8 @@ func run(args []string) *checkers.Checker {
 	}
 
 	msg := fmt.Sprintf("load average: %.2f, %.2f, %.2f", loadavgs[0], loadavgs[1], loadavgs[2])
+	if opts.PerCPU {
+		msg += fmt.Sprintf(" (%.2f, %.2f, %.2f per CPU of %d CPUs)", loads[0], loads[1], loads[2], numCPU)
+	}
 	return checkers.NewChecker(result, msg)
 }
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThreshold(t *testing.T) {
+	th, err := parseThreshold("4,3,2")
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.Equal(t, [3]float64{4, 3, 2}, th, "thresholds for loadavg1,5,15")
+
+	th, err = parseThreshold("0.8")
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.Equal(t, [3]float64{0.8, 0.8, 0.8}, th, "one threshold for all")
+
+	_, err = parseThreshold("4,3")
+	assert.NotNil(t, err, "err should not be nil")
+}
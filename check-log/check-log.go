@@ -12,11 +12,12 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 type logOpts struct {
+	pluginutil.Options
 	LogFile         string  `short:"f" long:"file" value-name:"FILE" description:"Path to log file"`
 	Pattern         string  `short:"p" long:"pattern" required:"true" value-name:"PAT" description:"Pattern to search for"`
 	Exclude         string  `short:"E" long:"exclude" value-name:"PAT" description:"Pattern to exclude from matching"`
@@ -82,9 +83,7 @@ func (opts *logOpts) prepare() error {
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "LOG"
-	ckr.Exit()
+	pluginutil.Main("LOG", run)
 }
 
 func regCompileWithCase(ptn string, caseInsensitive bool) (*regexp.Regexp, error) {
@@ -96,17 +95,16 @@ func regCompileWithCase(ptn string, caseInsensitive bool) (*regexp.Regexp, error
 
 func parseArgs(args []string) (*logOpts, error) {
 	opts := &logOpts{}
-	_, err := flags.ParseArgs(opts, args)
+	err := pluginutil.Parse(opts, args)
 	return opts, err
 }
 
 func run(args []string) *checkers.Checker {
-	opts, err := parseArgs(args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&logOpts{}, args)
+}
 
-	err = opts.prepare()
+func (opts *logOpts) Run() *checkers.Checker {
+	err := opts.prepare()
 	if err != nil {
 		return checkers.Unknown(err.Error())
 	}
@@ -143,14 +141,15 @@ func run(args []string) *checkers.Checker {
 
 func (opts *logOpts) searchLog(logFile string) (int64, int64, string, error) {
 	stateFile := getStateFile(opts.StateDir, logFile)
-	skipBytes := int64(0)
+	state := &logState{}
 	if !opts.NoState {
-		s, err := getBytesToSkip(stateFile)
+		s, err := loadState(stateFile)
 		if err != nil {
 			return 0, 0, "", err
 		}
-		skipBytes = s
+		state = s
 	}
+	skipBytes := state.SkipBytes
 
 	f, err := os.Open(logFile)
 	if err != nil {
@@ -164,8 +163,12 @@ func (opts *logOpts) searchLog(logFile string) (int64, int64, string, error) {
 	}
 
 	rotated := false
+	ino := inode(stat)
 	if stat.Size() < skipBytes {
 		rotated = true
+	} else if state.Inode != 0 && ino != 0 && state.Inode != ino {
+		// rotated and grown larger than the previous file already
+		rotated = true
 	} else if skipBytes > 0 {
 		f.Seek(skipBytes, 0)
 	}
@@ -182,7 +185,7 @@ func (opts *logOpts) searchLog(logFile string) (int64, int64, string, error) {
 	}
 
 	if !opts.NoState {
-		err = writeBytesToSkip(stateFile, skipBytes)
+		err = saveState(stateFile, &logState{SkipBytes: skipBytes, Inode: ino})
 		if err != nil {
 			log.Printf("writeByteToSkip failed: %s\n", err.Error())
 		}
@@ -248,26 +251,61 @@ func getStateFile(stateDir, f string) string {
 	return filepath.Join(stateDir, stateRe.ReplaceAllString(f, `$1`+string(filepath.Separator)))
 }
 
-func getBytesToSkip(f string) (int64, error) {
+// logState is the position read so far and the inode of the log file. The
+// state file holds them separated by a space, or only the position if it was
+// written by an older version.
+type logState struct {
+	SkipBytes int64
+	Inode     uint64
+}
+
+func loadState(f string) (*logState, error) {
 	_, err := os.Stat(f)
 	if err != nil {
-		return 0, nil
+		return &logState{}, nil
 	}
 	b, err := ioutil.ReadFile(f)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("invalid state file: %s", f)
 	}
-	i, err := strconv.ParseInt(strings.Trim(string(b), " \r\n"), 10, 64)
+	state := &logState{}
+	state.SkipBytes, err = strconv.ParseInt(fields[0], 10, 64)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if len(fields) > 1 {
+		state.Inode, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return i, nil
+	return state, nil
 }
 
-func writeBytesToSkip(f string, num int64) error {
+func saveState(f string, state *logState) error {
 	err := os.MkdirAll(filepath.Dir(f), 0755)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(f, []byte(fmt.Sprintf("%d", num)), 0644)
+	content := fmt.Sprintf("%d", state.SkipBytes)
+	if state.Inode != 0 {
+		content += fmt.Sprintf(" %d", state.Inode)
+	}
+	return ioutil.WriteFile(f, []byte(content), 0644)
+}
+
+func getBytesToSkip(f string) (int64, error) {
+	state, err := loadState(f)
+	if err != nil {
+		return 0, err
+	}
+	return state.SkipBytes, nil
+}
+
+func writeBytesToSkip(f string, num int64) error {
+	return saveState(f, &logState{SkipBytes: num})
 }
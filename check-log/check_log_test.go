@@ -8,6 +8,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -281,6 +282,7 @@ func TestSearchReaderWithLevel(t *testing.T) {
 	ptn := `FATAL level:([0-9]+)`
 	opts, _ := parseArgs([]string{"-s", dir, "-f", logf, "-i", "-p", ptn, "--critical-level=17", "--warning-level=11"})
 	if !reflect.DeepEqual(&logOpts{
+		Options:         pluginutil.Options{RetryInterval: 1, Format: "plain"},
 		StateDir:        dir,
 		LogFile:         filepath.Join(dir, "dummy"),
 		CaseInsensitive: true,
@@ -305,3 +307,67 @@ Fatal level:17
 	assert.Equal(t, "FATAL level:22\nFatal level:17\n", errLines, "invalid errLines")
 	assert.Equal(t, int64(len(content)), readBytes, "readBytes should be 26")
 }
+
+func TestRunWithRotationByInode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-log-test")
+	if err != nil {
+		t.Errorf("something went wrong")
+	}
+	defer os.RemoveAll(dir)
+
+	logf := filepath.Join(dir, "dummy")
+	fh, _ := os.Create(logf)
+	defer fh.Close()
+
+	ptn := `FATAL`
+	opts, _ := parseArgs([]string{"-s", dir, "-f", logf, "-p", ptn})
+	opts.prepare()
+
+	stateFile := getStateFile(opts.StateDir, logf)
+
+	l1 := "SUCCESS\n"
+	fh.WriteString(l1)
+	w, c, _, err := opts.searchLog(logf)
+	assert.Equal(t, err, nil, "err should be nil")
+	assert.Equal(t, int64(0), w, "something went wrong")
+	assert.Equal(t, int64(0), c, "something went wrong")
+
+	testRotateAndGrow := func() {
+		// the new file has grown larger than the old one before the next run
+		fh.Close()
+		os.Rename(logf, logf+".1")
+		fh, _ = os.Create(logf)
+
+		l2 := "FATAL\nSUCCESS\n"
+		fh.WriteString(l2)
+		w, c, errLines, err := opts.searchLog(logf)
+		assert.Equal(t, err, nil, "err should be nil")
+		assert.Equal(t, int64(1), w, "something went wrong")
+		assert.Equal(t, int64(1), c, "something went wrong")
+		assert.Equal(t, "FATAL\n", errLines, "something went wrong")
+
+		bytes, _ := getBytesToSkip(stateFile)
+		assert.Equal(t, int64(len(l2)), bytes, "something went wrong")
+	}
+	testRotateAndGrow()
+}
+
+func TestLoadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-log-test")
+	if err != nil {
+		t.Errorf("something went wrong")
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "state")
+	ioutil.WriteFile(f, []byte("15"), 0644)
+	state, err := loadState(f)
+	assert.Equal(t, err, nil, "err should be nil")
+	assert.Equal(t, &logState{SkipBytes: 15}, state, "state written by older version should be read")
+
+	err = saveState(f, &logState{SkipBytes: 20, Inode: 1234})
+	assert.Equal(t, err, nil, "err should be nil")
+	state, err = loadState(f)
+	assert.Equal(t, err, nil, "err should be nil")
+	assert.Equal(t, &logState{SkipBytes: 20, Inode: 1234}, state, "something went wrong")
+}
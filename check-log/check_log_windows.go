@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+// inode returns 0 as there is no inode on Windows. Log rotation is detected
+// only by the file size shrinking.
+func inode(fi os.FileInfo) uint64 {
+	return 0
+}
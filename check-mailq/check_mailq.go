@@ -2,20 +2,17 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "Mailq"
-	ckr.Exit()
+	pluginutil.Main("Mailq", run)
 }
 
 type monitor struct {
@@ -46,18 +43,18 @@ func newMonitor(warning, critical int64) *monitor {
 	}
 }
 
-var opts struct {
+type mailqOpts struct {
+	pluginutil.Options
 	Warning  int64  `short:"w" long:"warning" default:"100" description:"number of messages in queue to generate warning"`
 	Critical int64  `short:"c" long:"critical" default:"200" description:"number of messages in queue to generate critical alert ( w < c )"`
 	Mta      string `short:"M" long:"mta" default:"postfix" description:"target mta"`
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&mailqOpts{}, args)
+}
 
+func (opts *mailqOpts) Run() *checkers.Checker {
 	var queue int64
 	queueStr := "0"
 	monitor := newMonitor(opts.Warning, opts.Critical)
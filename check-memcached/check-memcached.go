@@ -1,39 +1,41 @@
 package main
 
 import (
-	"os"
-	"time"
-
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
-var opts struct {
-	Host    string `short:"H" long:"host" default:"localhost" description:"Hostname"`
-	Port    string `short:"p" long:"port" default:"11211" description:"Port"`
-	Timeout uint64 `short:"t" long:"timeout" default:"3" description:"Dial Timeout in sec"`
-	Key     string `short:"k" long:"key" required:"true" description:"Cache key used within set and get test"`
+type memcachedOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Host string `short:"H" long:"host" default:"localhost" description:"Hostname"`
+	Port string `short:"p" long:"port" default:"11211" description:"Port"`
+	Key  string `short:"k" long:"key" required:"true" description:"Cache key used within set and get test"`
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "Memcached"
-	ckr.Exit()
+	pluginutil.Main("Memcached", run)
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&memcachedOpts{}, args)
+}
+
+// CustomizeParser keeps the default timeout of 3 seconds, which is shorter
+// than the other plugins
+func (opts *memcachedOpts) CustomizeParser(p *flags.Parser) {
+	pluginutil.SetDefault(p, "timeout", "3")
+}
 
+func (opts *memcachedOpts) Run() *checkers.Checker {
 	mc := memcache.New(opts.Host + ":" + opts.Port)
-	mc.Timeout = time.Duration(opts.Timeout) * time.Second
+	mc.Timeout = opts.TimeoutDuration()
 
-	err = mc.Set(&memcache.Item{Key: opts.Key, Value: []byte("Check key"), Expiration: 240})
+	err := mc.Set(&memcache.Item{Key: opts.Key, Value: []byte("Check key"), Expiration: 240})
 	if err != nil {
-		return checkers.Critical("couldn't set a key: " + err.Error())
+		return pluginutil.Failure(checkers.Critical("couldn't set a key: " + err.Error()))
 	}
 
 	item, err := mc.Get(opts.Key)
@@ -6,11 +6,14 @@ import (
 	"strings"
 
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 	"github.com/ziutek/mymysql/mysql"
 	_ "github.com/ziutek/mymysql/native"
 )
 
 type mysqlSetting struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
 	Host string `short:"H" long:"host" default:"localhost" description:"Hostname"`
 	Port string `short:"p" long:"port" default:"3306" description:"Port"`
 	User string `short:"u" long:"user" default:"root" description:"Username"`
@@ -43,12 +46,15 @@ SubCommands:`)
 		}
 		os.Exit(1)
 	}
-	ckr := fn(argv)
-	ckr.Name = fmt.Sprintf("MySQL %s", strings.ToUpper(string(subCmd[0]))+subCmd[1:])
-	ckr.Exit()
+	name := fmt.Sprintf("MySQL %s", strings.ToUpper(string(subCmd[0]))+subCmd[1:])
+	pluginutil.Main(name, func([]string) *checkers.Checker {
+		return fn(argv)
+	})
 }
 
 func newMySQL(m mysqlSetting) mysql.Conn {
 	target := fmt.Sprintf("%s:%s", m.Host, m.Port)
-	return mysql.New("tcp", "", target, m.User, m.Pass, "")
+	db := mysql.New("tcp", "", target, m.User, m.Pass, "")
+	db.SetTimeout(m.TimeoutDuration())
+	return db
 }
@@ -2,10 +2,10 @@ package main
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 type connectionOpts struct {
@@ -15,17 +15,18 @@ type connectionOpts struct {
 }
 
 func checkConnection(args []string) *checkers.Checker {
-	opts := connectionOpts{}
-	psr := flags.NewParser(&opts, flags.Default)
-	psr.Usage = "connection [OPTIONS]"
-	_, err := psr.ParseArgs(args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&connectionOpts{}, args)
+}
+
+func (opts *connectionOpts) CustomizeParser(p *flags.Parser) {
+	p.Usage = "connection [OPTIONS]"
+}
+
+func (opts *connectionOpts) Run() *checkers.Checker {
 	db := newMySQL(opts.mysqlSetting)
-	err = db.Connect()
+	err := db.Connect()
 	if err != nil {
-		return checkers.Unknown("couldn't connect DB")
+		return pluginutil.Failure(checkers.Unknown("couldn't connect DB"))
 	}
 	defer db.Close()
 
@@ -33,6 +34,9 @@ func checkConnection(args []string) *checkers.Checker {
 	if err != nil {
 		return checkers.Unknown("couldn't execute query")
 	}
+	if len(rows) == 0 {
+		return checkers.Unknown("couldn't get Threads_Connected")
+	}
 
 	idxValue := res.Map("Value")
 	threadsConnected := rows[0].Int64(idxValue)
@@ -2,10 +2,11 @@ package main
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"github.com/ziutek/mymysql/mysql"
 )
 
 type replicationOpts struct {
@@ -15,17 +16,18 @@ type replicationOpts struct {
 }
 
 func checkReplication(args []string) *checkers.Checker {
-	opts := replicationOpts{}
-	psr := flags.NewParser(&opts, flags.Default)
-	psr.Usage = "replication [OPTIONS]"
-	_, err := psr.ParseArgs(args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&replicationOpts{}, args)
+}
+
+func (opts *replicationOpts) CustomizeParser(p *flags.Parser) {
+	p.Usage = "replication [OPTIONS]"
+}
+
+func (opts *replicationOpts) Run() *checkers.Checker {
 	db := newMySQL(opts.mysqlSetting)
-	err = db.Connect()
+	err := db.Connect()
 	if err != nil {
-		return checkers.Unknown("couldn't connect DB")
+		return pluginutil.Failure(checkers.Unknown("couldn't connect DB"))
 	}
 	defer db.Close()
 
@@ -43,11 +45,19 @@ func checkReplication(args []string) *checkers.Checker {
 	idxSecondsBehindMaster := res.Map("Seconds_Behind_Master")
 	ioThreadStatus := rows[0].Str(idxIoThreadRunning)
 	sqlThreadStatus := rows[0].Str(idxSQLThreadRunning)
-	secondsBehindMaster := rows[0].Int64(idxSecondsBehindMaster)
 
-	if ioThreadStatus == "No" || sqlThreadStatus == "No" {
-		return checkers.Critical("MySQL replication has been stopped")
+	// the IO thread is "Connecting" while the master is unreachable
+	if ioThreadStatus != "Yes" {
+		return checkers.Critical(stoppedMessage("IO", ioThreadStatus, lastError(rows[0], res.Map("Last_IO_Error"))))
+	}
+	if sqlThreadStatus != "Yes" {
+		return checkers.Critical(stoppedMessage("SQL", sqlThreadStatus, lastError(rows[0], res.Map("Last_SQL_Error"))))
 	}
+	// Seconds_Behind_Master is NULL unless the replication is running
+	if rows[0][idxSecondsBehindMaster] == nil {
+		return checkers.Critical("MySQL replication behind master is unknown")
+	}
+	secondsBehindMaster := rows[0].Int64(idxSecondsBehindMaster)
 
 	checkSt := checkers.OK
 	msg := fmt.Sprintf("MySQL replication behind master %d seconds", secondsBehindMaster)
@@ -58,3 +68,20 @@ func checkReplication(args []string) *checkers.Checker {
 	}
 	return checkers.NewChecker(checkSt, msg)
 }
+
+// lastError returns the column of the error, which old versions of MySQL
+// don't have
+func lastError(row mysql.Row, idx int) string {
+	if idx < 0 {
+		return ""
+	}
+	return row.Str(idx)
+}
+
+func stoppedMessage(thread, status, lastError string) string {
+	msg := fmt.Sprintf("MySQL replication has been stopped (%s thread: %s)", thread, status)
+	if lastError != "" {
+		msg += ": " + lastError
+	}
+	return msg
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 type uptimeOpts struct {
@@ -23,17 +23,18 @@ func uptime2str(uptime int64) string {
 }
 
 func checkUptime(args []string) *checkers.Checker {
-	opts := uptimeOpts{}
-	psr := flags.NewParser(&opts, flags.Default)
-	psr.Usage = "uptime [OPTIONS]"
-	_, err := psr.ParseArgs(args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&uptimeOpts{}, args)
+}
+
+func (opts *uptimeOpts) CustomizeParser(p *flags.Parser) {
+	p.Usage = "uptime [OPTIONS]"
+}
+
+func (opts *uptimeOpts) Run() *checkers.Checker {
 	db := newMySQL(opts.mysqlSetting)
-	err = db.Connect()
+	err := db.Connect()
 	if err != nil {
-		return checkers.Unknown("couldn't connect DB")
+		return pluginutil.Failure(checkers.Unknown("couldn't connect DB"))
 	}
 	defer db.Close()
 
@@ -41,6 +42,9 @@ func checkUptime(args []string) *checkers.Checker {
 	if err != nil {
 		return checkers.Unknown("couldn't execute query")
 	}
+	if len(rows) == 0 {
+		return checkers.Unknown("couldn't get Uptime")
+	}
 
 	idxValue := res.Map("Value")
 	upTime := rows[0].Int64(idxValue)
@@ -4,33 +4,45 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
-var opts struct {
+type ntpOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
 	Crit float64 `short:"c" long:"critical" default:"100" description:"critical if the ntpoffset is over"`
 	Warn float64 `short:"w" long:"warning" default:"50" description:"warning if the ntpoffset is over"`
+
+	Server string `short:"s" long:"server" description:"NTP server to query with SNTP instead of the local ntpd or chronyd"`
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "NTP"
-	ckr.Exit()
+	pluginutil.Main("NTP", run)
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&ntpOpts{}, args)
+}
 
-	offset, err := getNtpOffset()
+func (opts *ntpOpts) Run() *checkers.Checker {
+	var offset float64
+	var err error
+	if opts.Server != "" {
+		offset, err = getSNTPOffset(opts.Server, opts.TimeoutDuration())
+		if err != nil {
+			return pluginutil.Failure(checkers.Unknown(err.Error()))
+		}
+	} else {
+		offset, err = getNtpOffset()
+		if isNotFound(err) {
+			offset, err = getChronyOffset()
+		}
+	}
 	if err != nil {
 		return checkers.Unknown(err.Error())
 	}
@@ -51,6 +63,14 @@ func run(args []string) *checkers.Checker {
 	return checkers.NewChecker(chkSt, msg)
 }
 
+// isNotFound reports whether the command is not installed
+func isNotFound(err error) bool {
+	if e, ok := err.(*exec.Error); ok {
+		return e.Err == exec.ErrNotFound
+	}
+	return false
+}
+
 func getNtpOffset() (float64, error) {
 	var offset float64
 	var err error
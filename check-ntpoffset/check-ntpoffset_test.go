@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSNTP starts an SNTP server whose clock is ahead by skew
+func serveSNTP(t *testing.T, skew time.Duration, stratum byte) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			_, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			res := make([]byte, 48)
+			// LI = 0, VN = 4, Mode = 4 (server)
+			res[0] = 0x24
+			res[1] = stratum
+			copy(res[12:16], "RATE")
+			copy(res[24:32], buf[40:48])
+			now := time.Now().Add(skew)
+			putNTPTime(res[32:40], now)
+			putNTPTime(res[40:48], now)
+			pc.WriteTo(res, addr)
+		}
+	}()
+	return pc
+}
+
+func TestSNTP(t *testing.T) {
+	pc := serveSNTP(t, 500*time.Millisecond, 2)
+	defer pc.Close()
+
+	offset, err := getSNTPOffset(pc.LocalAddr().String(), time.Second)
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.InDelta(t, 500, offset, 50, "offset should be about 500ms")
+
+	ckr := run([]string{"-s", pc.LocalAddr().String(), "-w", "100", "-c", "1000"})
+	assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+
+	ckr = run([]string{"-s", pc.LocalAddr().String(), "-w", "100", "-c", "200"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+}
+
+func TestSNTPKissOfDeath(t *testing.T) {
+	pc := serveSNTP(t, 0, 0)
+	defer pc.Close()
+
+	_, err := getSNTPOffset(pc.LocalAddr().String(), time.Second)
+	assert.Equal(t, "kiss-o'-death from "+pc.LocalAddr().String()+": RATE", err.Error(), "something went wrong")
+}
+
+func TestParseChronyTracking(t *testing.T) {
+	output := `Reference ID    : A9FEA97B (169.254.169.123)
+Stratum         : 4
+Ref time (UTC)  : Thu Oct 15 08:00:00 2026
+System time     : 0.012345000 seconds fast of NTP time
+Last offset     : -0.000006000 seconds
+`
+	offset, err := parseChronyTracking(output)
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.InDelta(t, -12.345, offset, 0.0001, "fast local clock should be negative offset")
+
+	_, err = parseChronyTracking("506 Cannot talk to daemon\n")
+	assert.NotNil(t, err, "err should not be nil")
+}
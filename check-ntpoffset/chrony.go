@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// getChronyOffset returns the offset of NTP time relative to the local clock
+// in milliseconds as ntpq does, from the output of `chronyc tracking`
+func getChronyOffset() (float64, error) {
+	output, err := exec.Command("chronyc", "-n", "tracking").Output()
+	if err != nil {
+		return 0, err
+	}
+	return parseChronyTracking(string(output))
+}
+
+func parseChronyTracking(output string) (float64, error) {
+	// System time     : 0.000012345 seconds slow of NTP time
+	for _, line := range strings.Split(output, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "System time" {
+			continue
+		}
+		fields := strings.Fields(kv[1])
+		if len(fields) < 3 {
+			break
+		}
+		sec, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, err
+		}
+		if fields[2] == "fast" {
+			sec = -sec
+		}
+		return sec * 1000, nil
+	}
+	return 0, errors.New("couldn't get ntp offset. chronyd process may be down")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// seconds from 1900-01-01 (NTP epoch) to 1970-01-01 (Unix epoch)
+const ntpEpochOffset = 2208988800
+
+func ntpTime(b []byte) time.Time {
+	sec := binary.BigEndian.Uint32(b[0:4])
+	frac := binary.BigEndian.Uint32(b[4:8])
+	nsec := (int64(frac) * 1e9) >> 32
+	return time.Unix(int64(sec)-ntpEpochOffset, nsec)
+}
+
+func putNTPTime(b []byte, t time.Time) {
+	sec := uint32(t.Unix() + ntpEpochOffset)
+	frac := uint32((int64(t.Nanosecond()) << 32) / 1e9)
+	binary.BigEndian.PutUint32(b[0:4], sec)
+	binary.BigEndian.PutUint32(b[4:8], frac)
+}
+
+// getSNTPOffset queries the server with SNTP (RFC 4330) and returns the
+// offset of the server clock relative to the local clock in milliseconds
+func getSNTPOffset(server string, timeout time.Duration) (float64, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	req := make([]byte, 48)
+	// LI = 0, VN = 4, Mode = 3 (client)
+	req[0] = 0x23
+	t1 := time.Now()
+	putNTPTime(req[40:48], t1)
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	res := make([]byte, 48)
+	n, err := conn.Read(res)
+	if err != nil {
+		return 0, err
+	}
+	t4 := time.Now()
+	if n < 48 {
+		return 0, errors.New("short SNTP response")
+	}
+	if mode := res[0] & 0x07; mode != 4 {
+		return 0, fmt.Errorf("unexpected SNTP mode: %d", mode)
+	}
+	if stratum := res[1]; stratum == 0 {
+		return 0, fmt.Errorf("kiss-o'-death from %s: %s", server, string(res[12:16]))
+	}
+	t2 := ntpTime(res[32:40])
+	t3 := ntpTime(res[40:48])
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	return float64(offset) / float64(time.Millisecond), nil
+}
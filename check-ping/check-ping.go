@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+type pingOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Host     string  `short:"H" long:"host" required:"true" description:"Host name or IP Address to ping"`
+	Count    int     `short:"n" long:"count" default:"5" description:"Number of packets to send"`
+	Interval float64 `short:"i" long:"interval" default:"1" description:"Seconds between packets"`
+	Warning  string  `short:"w" long:"warning" default:"100,20%" value-name:"RTA,LOSS%" description:"Round trip average (milliseconds) and packet loss to result in warning status"`
+	Critical string  `short:"c" long:"critical" default:"500,60%" value-name:"RTA,LOSS%" description:"Round trip average (milliseconds) and packet loss to result in critical status"`
+	IPv6     bool    `short:"6" long:"ipv6" description:"Use IPv6"`
+}
+
+func main() {
+	pluginutil.Main("Ping", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&pingOpts{}, args)
+}
+
+// threshold is the round trip average in milliseconds and the packet loss
+// in percent as Nagios' check_ping
+type threshold struct {
+	rta  float64
+	loss float64
+}
+
+func parseThreshold(s string) (*threshold, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 2 || !strings.HasSuffix(fields[1], "%") {
+		return nil, fmt.Errorf("invalid threshold: %s (must be RTA,LOSS%%)", s)
+	}
+	rta, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold: %s", s)
+	}
+	loss, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "%"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold: %s", s)
+	}
+	return &threshold{rta, loss}, nil
+}
+
+func (t *threshold) exceeded(rta, loss float64) bool {
+	return rta >= t.rta || loss >= t.loss
+}
+
+// listen opens an ICMP endpoint. It falls back to an unprivileged ICMP
+// (UDP) socket when raw sockets are not allowed, which requires
+// net.ipv4.ping_group_range on Linux.
+func listen(v6 bool) (c *icmp.PacketConn, privileged bool, err error) {
+	network, udp, address := "ip4:icmp", "udp4", "0.0.0.0"
+	if v6 {
+		network, udp, address = "ip6:ipv6-icmp", "udp6", "::"
+	}
+	if c, err = icmp.ListenPacket(network, address); err == nil {
+		return c, true, nil
+	}
+	c, err = icmp.ListenPacket(udp, address)
+	return c, false, err
+}
+
+// pinger sends echo requests and collects the round trip times
+type pinger struct {
+	conn       *icmp.PacketConn
+	privileged bool
+	ip         net.IP
+	id         int
+
+	mu   sync.Mutex
+	sent map[int]time.Time
+	rtts map[int]time.Duration
+}
+
+func (p *pinger) addr() net.Addr {
+	if p.privileged {
+		return &net.IPAddr{IP: p.ip}
+	}
+	return &net.UDPAddr{IP: p.ip}
+}
+
+func (p *pinger) send(seq int) error {
+	typ := icmp.Type(ipv4.ICMPTypeEcho)
+	if p.ip.To4() == nil {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: p.id, Seq: seq, Data: []byte("check-ping")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.sent[seq] = time.Now()
+	p.mu.Unlock()
+	_, err = p.conn.WriteTo(b, p.addr())
+	return err
+}
+
+// receive reads replies until all of count packets are received or the
+// read deadline, which is set after the last packet is sent
+func (p *pinger) receive(count int) {
+	proto, reply := 1, icmp.Type(ipv4.ICMPTypeEchoReply)
+	if p.ip.To4() == nil {
+		proto, reply = 58, ipv6.ICMPTypeEchoReply
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		received := time.Now()
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || msg.Type != reply {
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || !sameHost(peer, p.ip) {
+			continue
+		}
+		// the kernel rewrites the ID of unprivileged sockets
+		if p.privileged && echo.ID != p.id {
+			continue
+		}
+		p.mu.Lock()
+		sent, ok := p.sent[echo.Seq]
+		if _, dup := p.rtts[echo.Seq]; ok && !dup {
+			p.rtts[echo.Seq] = received.Sub(sent)
+		}
+		done := len(p.rtts) >= count
+		p.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+func sameHost(addr net.Addr, ip net.IP) bool {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP.Equal(ip)
+	case *net.UDPAddr:
+		return a.IP.Equal(ip)
+	}
+	return false
+}
+
+func (opts *pingOpts) Run() *checkers.Checker {
+	warning, err := parseThreshold(opts.Warning)
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	critical, err := parseThreshold(opts.Critical)
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	if opts.Count < 1 {
+		return checkers.Unknown("count must be greater than 0")
+	}
+	if opts.Timeout <= 0 {
+		return checkers.Unknown("timeout must be greater than 0")
+	}
+	network := "ip4"
+	if opts.IPv6 {
+		network = "ip6"
+	}
+	addr, err := net.ResolveIPAddr(network, opts.Host)
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	conn, privileged, err := listen(opts.IPv6)
+	if err != nil {
+		return checkers.Unknown(fmt.Sprintf("couldn't open ICMP socket: %s", err))
+	}
+	defer conn.Close()
+
+	p := &pinger{
+		conn:       conn,
+		privileged: privileged,
+		ip:         addr.IP,
+		id:         os.Getpid() & 0xffff,
+		sent:       make(map[int]time.Time),
+		rtts:       make(map[int]time.Duration),
+	}
+	done := make(chan struct{})
+	go func() {
+		p.receive(opts.Count)
+		close(done)
+	}()
+	interval := time.Duration(opts.Interval * float64(time.Second))
+	for seq := 0; seq < opts.Count; seq++ {
+		if seq > 0 {
+			select {
+			case <-done:
+			case <-time.After(interval):
+			}
+		}
+		if err := p.send(seq); err != nil {
+			return pluginutil.Failure(checkers.Critical(err.Error()))
+		}
+	}
+	// the timeout is for the reply to the last packet, so that it doesn't
+	// depend on the count and the interval
+	conn.SetReadDeadline(time.Now().Add(opts.TimeoutDuration()))
+	<-done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	received := len(p.rtts)
+	loss := float64(opts.Count-received) * 100 / float64(opts.Count)
+	if received == 0 {
+		return pluginutil.Failure(checkers.Critical(fmt.Sprintf("packet loss = 100%%, %d packets sent to %s", opts.Count, addr.IP)))
+	}
+	var total time.Duration
+	for _, rtt := range p.rtts {
+		total += rtt
+	}
+	rta := float64(total) / float64(received) / float64(time.Millisecond)
+
+	chkSt := checkers.OK
+	if critical.exceeded(rta, loss) {
+		chkSt = checkers.CRITICAL
+	} else if warning.exceeded(rta, loss) {
+		chkSt = checkers.WARNING
+	}
+	msg := fmt.Sprintf("packet loss = %.0f%%, rta = %.3f ms (%s)", loss, rta, addr.IP)
+	return checkers.NewChecker(chkSt, msg)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThreshold(t *testing.T) {
+	th, err := parseThreshold("100,20%")
+	assert.Nil(t, err, "err should be nil")
+	assert.Equal(t, &threshold{100, 20}, th, "something went wrong")
+
+	assert.True(t, th.exceeded(100, 0), "should be exceeded")
+	assert.True(t, th.exceeded(0.5, 20), "should be exceeded")
+	assert.False(t, th.exceeded(99.9, 19), "should not be exceeded")
+
+	for _, s := range []string{"100", "100,20", "abc,20%", "100,x%"} {
+		_, err := parseThreshold(s)
+		assert.Error(t, err, "should be an error: "+s)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if c, _, err := listen(false); err != nil {
+		t.Skipf("ICMP is not allowed: %s", err)
+	} else {
+		c.Close()
+	}
+
+	ckr := run([]string{"-H", "127.0.0.1", "-n", "3", "-i", "0.1"})
+	assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	assert.Regexp(t, `^packet loss = 0%, rta = \d+\.\d{3} ms \(127\.0\.0\.1\)$`, ckr.Message, "something went wrong")
+
+	ckr = run([]string{"-H", "127.0.0.1", "-n", "2", "-i", "0.1", "-w", "0,20%"})
+	assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+
+	// the packets are sent longer than the timeout
+	ckr = run([]string{"-H", "127.0.0.1", "-n", "4", "-i", "0.2", "-t", "0.3"})
+	assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	assert.Regexp(t, `^packet loss = 0%`, ckr.Message, "something went wrong")
+
+	ckr = run([]string{"-H", "127.0.0.1", "-t", "0"})
+	assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+}
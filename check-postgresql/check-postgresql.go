@@ -2,29 +2,58 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"strings"
 
+	"github.com/jessevdk/go-flags"
 	_ "github.com/lib/pq"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 var commands = map[string](func([]string) *checkers.Checker){
-	"connection": checkConnection,
+	"connection":  checkConnection,
+	"replication": checkReplication,
 }
 
 type postgresqlSetting struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
 	Host     string `short:"H" long:"host" default:"localhost" description:"Hostname"`
 	Port     string `short:"p" long:"port" default:"5432" description:"Port"`
 	User     string `short:"u" long:"user" default:"postgres" description:"Username"`
-	Password string `short:"P" long:"password" default:"" description:"Password"`
-	SSLmode  string `short:"s" long:"sslmode" default:"disable" description:"SSLmode"`
-	Timeout  int    `short:"t" long:"timeout" default:"5" description:"Maximum wait for connection, in seconds."`
+	Password string `short:"P" long:"password" default:"" description:"Password (default: looked up in the password file, PGPASSFILE or ~/.pgpass)"`
+	Database string `short:"d" long:"dbname" default:"postgres" description:"Database name"`
+	SSLmode  string `short:"s" long:"sslmode" default:"disable" description:"SSLmode (disable, require, verify-ca or verify-full)"`
 }
 
 func (p postgresqlSetting) getDriverAndDataSourceName() (string, string) {
-	dataSourceName := fmt.Sprintf("user=%s password=%s host=%s port=%s sslmode=%s connect_timeout=%d", p.User, p.Password, p.Host, p.Port, p.SSLmode, p.Timeout)
-	return "postgres", dataSourceName
+	password := p.Password
+	if password == "" {
+		password, _ = readPgpass(p.Host, p.Port, p.Database, p.User)
+	}
+	params := []string{
+		"user=" + quoteParam(p.User),
+		"host=" + quoteParam(p.Host),
+		"port=" + quoteParam(p.Port),
+		"dbname=" + quoteParam(p.Database),
+		"sslmode=" + quoteParam(p.SSLmode),
+		// connect_timeout is in whole seconds
+		fmt.Sprintf("connect_timeout=%d", int(math.Ceil(p.Timeout))),
+	}
+	if password != "" {
+		params = append(params, "password="+quoteParam(password))
+	}
+	return "postgres", strings.Join(params, " ")
+}
+
+// quoteParam quotes the value of a connection parameter so that it can
+// contain spaces and quotes
+func quoteParam(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `'`, `\'`, -1)
+	return "'" + s + "'"
 }
 
 func separateSub(argv []string) (string, []string) {
@@ -47,7 +76,14 @@ SubCommands:`)
 		}
 		os.Exit(1)
 	}
-	ckr := fn(argv)
-	ckr.Name = fmt.Sprintf("PostgreSQL %s", strings.Title(subCmd))
-	ckr.Exit()
+	pluginutil.Main(fmt.Sprintf("PostgreSQL %s", strings.Title(subCmd)), func([]string) *checkers.Checker {
+		return fn(argv)
+	})
+}
+
+// customizeParser keeps the default timeout of 5 seconds, which is shorter
+// than the other plugins
+func customizeParser(p *flags.Parser, usage string) {
+	p.Usage = usage
+	pluginutil.SetDefault(p, "timeout", "5")
 }
@@ -3,10 +3,10 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"os"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 type connectionOpts struct {
@@ -16,24 +16,25 @@ type connectionOpts struct {
 }
 
 func checkConnection(args []string) *checkers.Checker {
-	opts := connectionOpts{}
-	psr := flags.NewParser(&opts, flags.Default)
-	psr.Usage = "connection [OPTIONS]"
-	_, err := psr.ParseArgs(args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&connectionOpts{}, args)
+}
 
+func (opts *connectionOpts) CustomizeParser(p *flags.Parser) {
+	customizeParser(p, "connection [OPTIONS]")
+}
+
+func (opts *connectionOpts) Run() *checkers.Checker {
 	db, err := sql.Open(opts.getDriverAndDataSourceName())
 	if err != nil {
 		return checkers.Unknown(err.Error())
 	}
 	defer db.Close()
 
+	// the connection is made by the first query
 	statActivityCount := 0
 	err = db.QueryRow("SELECT COUNT(*) AS cnt FROM pg_stat_activity").Scan(&statActivityCount)
 	if err != nil {
-		return checkers.Unknown(err.Error())
+		return pluginutil.Failure(checkers.Unknown(err.Error()))
 	}
 
 	checkSt := checkers.OK
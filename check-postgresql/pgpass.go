@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pgpassFile returns the path of the password file as libpq does
+func pgpassFile() string {
+	if f := os.Getenv("PGPASSFILE"); f != "" {
+		return f
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// lookupPgpass finds the password for the connection in the password file,
+// whose lines are in the form of hostname:port:database:username:password
+func lookupPgpass(r io.Reader, host, port, database, user string) (string, bool) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		if matchPgpass(fields[0], host) && matchPgpass(fields[1], port) &&
+			matchPgpass(fields[2], database) && matchPgpass(fields[3], user) {
+			return fields[4], true
+		}
+	}
+	return "", false
+}
+
+// splitPgpassLine splits the line by colons, which can be escaped by a
+// backslash as well as backslashes
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var field []rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			field = append(field, c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ':':
+			fields = append(fields, string(field))
+			field = field[:0]
+		default:
+			field = append(field, c)
+		}
+	}
+	return append(fields, string(field))
+}
+
+func matchPgpass(pattern, value string) bool {
+	return pattern == "*" || pattern == value
+}
+
+func readPgpass(host, port, database, user string) (string, bool) {
+	path := pgpassFile()
+	if path == "" {
+		return "", false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	// libpq ignores the file when it is readable by others
+	if fi, err := f.Stat(); err != nil || fi.Mode().Perm()&0077 != 0 {
+		return "", false
+	}
+	// unix domain socket connections match "localhost"
+	if host == "" || strings.HasPrefix(host, "/") {
+		host = "localhost"
+	}
+	return lookupPgpass(f, host, port, database, user)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupPgpass(t *testing.T) {
+	pgpass := `# comment
+db.example.com:5432:app:monitor:secret
+*:*:*:monitor:fallback
+localhost:5432:*:pa\:ss:with\:colon\\
+`
+	testCases := []struct {
+		host, port, database, user string
+		password                   string
+		found                      bool
+	}{
+		{"db.example.com", "5432", "app", "monitor", "secret", true},
+		{"db.example.com", "5432", "postgres", "monitor", "fallback", true},
+		{"localhost", "5432", "postgres", "pa:ss", `with:colon\`, true},
+		{"localhost", "5432", "postgres", "postgres", "", false},
+	}
+	for _, tc := range testCases {
+		password, found := lookupPgpass(strings.NewReader(pgpass), tc.host, tc.port, tc.database, tc.user)
+		assert.Equal(t, tc.found, found, "something went wrong")
+		assert.Equal(t, tc.password, password, "something went wrong")
+	}
+}
+
+func TestGetDriverAndDataSourceName(t *testing.T) {
+	p := postgresqlSetting{
+		Host:     "localhost",
+		Port:     "5432",
+		User:     "monitor",
+		Password: `it's \secret`,
+		Database: "postgres",
+		SSLmode:  "require",
+	}
+	p.Timeout = 5
+	driver, dsn := p.getDriverAndDataSourceName()
+	assert.Equal(t, "postgres", driver, "something went wrong")
+	assert.Equal(t, `user='monitor' host='localhost' port='5432' dbname='postgres' sslmode='require' connect_timeout=5 password='it\'s \\secret'`, dsn, "something went wrong")
+
+	p.Timeout = 0.5
+	_, dsn = p.getDriverAndDataSourceName()
+	assert.Contains(t, dsn, " connect_timeout=1 ", "something went wrong")
+}
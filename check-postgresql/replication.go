@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type replicationOpts struct {
+	postgresqlSetting
+	Role string  `long:"role" description:"Expected role of the server, primary or standby (default: either)"`
+	Warn float64 `short:"w" long:"warning" default:"60" description:"warning if the replication lag is over (seconds)"`
+	Crit float64 `short:"c" long:"critical" default:"300" description:"critical if the replication lag is over (seconds)"`
+}
+
+// lagQuery is the seconds since the last transaction replayed on a standby.
+// The lag is 0 when the standby has replayed all WAL it received as the
+// primary may simply be idle, so the WAL receiver is checked by
+// receiverQuery beforehand.
+const lagQuery = `SELECT CASE
+  WHEN pg_last_%[1]s_receive_%[2]s() = pg_last_%[1]s_replay_%[2]s() THEN 0
+  ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+END`
+
+// receiverQuery is the number of the WAL receivers streaming from the primary
+const receiverQuery = `SELECT count(*) FROM pg_stat_wal_receiver WHERE status = 'streaming'`
+
+func checkReplication(args []string) *checkers.Checker {
+	return pluginutil.Run(&replicationOpts{}, args)
+}
+
+func (opts *replicationOpts) CustomizeParser(p *flags.Parser) {
+	customizeParser(p, "replication [OPTIONS]")
+}
+
+func (opts *replicationOpts) Run() *checkers.Checker {
+	if opts.Role != "" && opts.Role != "primary" && opts.Role != "standby" {
+		return checkers.Unknown(fmt.Sprintf("invalid role: %s", opts.Role))
+	}
+
+	db, err := sql.Open(opts.getDriverAndDataSourceName())
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	defer db.Close()
+
+	// the connection is made by the first query
+	var inRecovery bool
+	err = db.QueryRow("SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	if err != nil {
+		return pluginutil.Failure(checkers.Unknown(err.Error()))
+	}
+	if !inRecovery {
+		if opts.Role == "standby" {
+			return checkers.Critical("PostgreSQL is not in recovery")
+		}
+		return checkers.Ok("PostgreSQL is primary")
+	}
+	if opts.Role == "primary" {
+		return checkers.Critical("PostgreSQL is in recovery")
+	}
+
+	var version int
+	err = db.QueryRow("SELECT current_setting('server_version_num')::integer").Scan(&version)
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	// pg_stat_wal_receiver is added in 9.6
+	if version >= 90600 {
+		var receivers int
+		err = db.QueryRow(receiverQuery).Scan(&receivers)
+		if err != nil {
+			return checkers.Unknown(err.Error())
+		}
+		if receivers == 0 {
+			return checkers.Critical("PostgreSQL has no WAL receiver streaming from the primary")
+		}
+	}
+	// the functions are renamed from xlog/location to wal/lsn in 10
+	query := fmt.Sprintf(lagQuery, "xlog", "location")
+	if version >= 100000 {
+		query = fmt.Sprintf(lagQuery, "wal", "lsn")
+	}
+	var lag sql.NullFloat64
+	err = db.QueryRow(query).Scan(&lag)
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	if !lag.Valid {
+		return checkers.Unknown("PostgreSQL has replayed no transaction since the recovery started")
+	}
+
+	checkSt := checkers.OK
+	msg := fmt.Sprintf("PostgreSQL replication lag %.3f seconds", lag.Float64)
+	if lag.Float64 > opts.Crit {
+		checkSt = checkers.CRITICAL
+	} else if lag.Float64 > opts.Warn {
+		checkSt = checkers.WARNING
+	}
+	return checkers.NewChecker(checkSt, msg)
+}
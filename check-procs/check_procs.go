@@ -6,33 +6,36 @@ import (
 	"regexp"
 	"strconv"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 // https://github.com/sensu-plugins/sensu-plugins-process-checks
-var opts struct {
-	WarnOver      *int64   `short:"w" long:"warn-over" value-name:"N" description:"Trigger a warning if over a number"`
-	CritOver      *int64   `short:"c" long:"critical-over" value-name:"N" description:"Trigger a critical if over a number"`
-	WarnUnder     int64   `short:"W" long:"warn-under" value-name:"N" default:"1" description:"Trigger a warning if under a number"`
-	CritUnder     int64   `short:"C" long:"critical-under" value-name:"N" default:"1" description:"Trigger a critial if under a number"`
-	MatchSelf     bool    `short:"m" long:"match-self" description:"Match itself"`
-	MatchParent   bool    `short:"M" long:"match-parent" description:"Match parent"`
-	CmdPat        string  `short:"p" long:"pattern" value-name:"PATTERN" description:"Match a command against this pattern"`
-	CmdExcludePat string  `short:"x" long:"exclude-pattern" value-name:"PATTERN" description:"Don't match against a pattern to prevent false positives"`
-	Ppid          string  `long:"ppid" value-name:"PPID" description:"Check against a specific PPID"`
-	FilePid       string  `short:"f" long:"file-pid" value-name:"PID" description:"Check against a specific PID"`
-	Vsz           int64   `short:"z" long:"virtual-memory-size" value-name:"VSZ" description:"Trigger on a Virtual Memory size is bigger than this"`
-	Rss           int64   `short:"r" long:"resident-set-size" value-name:"RSS" description:"Trigger on a Resident Set size is bigger than this"`
-	Pcpu          float64 `short:"P" long:"proportional-set-size" value-name:"PCPU" description:"Trigger on a Proportional Set Size is bigger than this"`
-	Thcount       int64   `short:"T" long:"thread-count" value-name:"THCOUNT" description:"Trigger on a Thread Count is bigger than this"`
-	State         string  `short:"s" long:"state" value-name:"STATE" description:"Trigger on a specific state, example: Z for zombie"`
-	User          string  `short:"u" long:"user" value-name:"USER" description:"Trigger on a specific user"`
-	Usernot       string  `short:"U" long:"user-not" value-name:"USER" description:"Trigger if not owned a specific user"`
-	EsecOver      int64   `short:"e" long:"esec-over" value-name:"SECONDS" description:"Match processes that older that this, in SECONDS"`
-	EsecUnder     int64   `short:"E" long:"esec-under" value-name:"SECONDS" description:"Match process that are younger than this, in SECONDS"`
-	CPUOver       int64   `short:"i" long:"cpu-over" value-name:"SECONDS" description:"Match processes cpu time that is older than this, in SECONDS"`
-	CPUUnder      int64   `short:"I" long:"cpu-under" value-name:"SECONDS" description:"Match processes cpu time that is younger than this, in SECONDS"`
+type procsOpts struct {
+	pluginutil.Options
+	WarnOver      *int64           `short:"w" long:"warn-over" value-name:"N" description:"Trigger a warning if over a number"`
+	CritOver      *int64           `short:"c" long:"critical-over" value-name:"N" description:"Trigger a critical if over a number"`
+	WarnUnder     int64            `short:"W" long:"warn-under" value-name:"N" default:"1" description:"Trigger a warning if under a number"`
+	CritUnder     int64            `short:"C" long:"critical-under" value-name:"N" default:"1" description:"Trigger a critial if under a number"`
+	WarnRange     pluginutil.Range `long:"warning-range" value-name:"RANGE" description:"Trigger a warning if outside a range instead of --warn-over and --warn-under. e.g. 1:, :50, 1:64"`
+	CritRange     pluginutil.Range `long:"critical-range" value-name:"RANGE" description:"Trigger a critical if outside a range instead of --critical-over and --critical-under. e.g. 1:, :50, 1:64"`
+	MatchSelf     bool             `short:"m" long:"match-self" description:"Match itself"`
+	MatchParent   bool             `short:"M" long:"match-parent" description:"Match parent"`
+	CmdPat        string           `short:"p" long:"pattern" value-name:"PATTERN" description:"Match a command against this pattern"`
+	CmdExcludePat string           `short:"x" long:"exclude-pattern" value-name:"PATTERN" description:"Don't match against a pattern to prevent false positives"`
+	Ppid          string           `long:"ppid" value-name:"PPID" description:"Check against a specific PPID"`
+	FilePid       string           `short:"f" long:"file-pid" value-name:"PID" description:"Check against a specific PID"`
+	Vsz           int64            `short:"z" long:"virtual-memory-size" value-name:"VSZ" description:"Trigger on a Virtual Memory size is bigger than this"`
+	Rss           int64            `short:"r" long:"resident-set-size" value-name:"RSS" description:"Trigger on a Resident Set size is bigger than this"`
+	Pcpu          float64          `short:"P" long:"proportional-set-size" value-name:"PCPU" description:"Trigger on a Proportional Set Size is bigger than this"`
+	Thcount       int64            `short:"T" long:"thread-count" value-name:"THCOUNT" description:"Trigger on a Thread Count is bigger than this"`
+	State         string           `short:"s" long:"state" value-name:"STATE" description:"Trigger on a specific state, example: Z for zombie"`
+	User          string           `short:"u" long:"user" value-name:"USER" description:"Trigger on a specific user"`
+	Usernot       string           `short:"U" long:"user-not" value-name:"USER" description:"Trigger if not owned a specific user"`
+	EsecOver      int64            `short:"e" long:"esec-over" value-name:"SECONDS" description:"Match processes that older that this, in SECONDS"`
+	EsecUnder     int64            `short:"E" long:"esec-under" value-name:"SECONDS" description:"Match process that are younger than this, in SECONDS"`
+	CPUOver       int64            `short:"i" long:"cpu-over" value-name:"SECONDS" description:"Match processes cpu time that is older than this, in SECONDS"`
+	CPUUnder      int64            `short:"I" long:"cpu-under" value-name:"SECONDS" description:"Match processes cpu time that is younger than this, in SECONDS"`
 }
 
 type procState struct {
@@ -50,17 +53,18 @@ type procState struct {
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "Procs"
-	ckr.Exit()
+	pluginutil.Main("Procs", run)
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
+	return pluginutil.Run(&procsOpts{}, args)
+}
+
+func (opts *procsOpts) Run() *checkers.Checker {
+	procs, err := getProcs()
 	if err != nil {
-		os.Exit(1)
+		return checkers.Unknown(err.Error())
 	}
-	procs, err := getProcs()
 	cmdPatRegexp := regexp.MustCompile(".*")
 	if opts.CmdPat != "" {
 		r, err := regexp.Compile(opts.CmdPat)
@@ -79,24 +83,34 @@ func run(args []string) *checkers.Checker {
 	}
 	var resultrocStates []procState
 	for _, proc := range procs {
-		if matchProc(proc, cmdPatRegexp, cmdExcludePatRegexp) {
+		if opts.matchProc(proc, cmdPatRegexp, cmdExcludePatRegexp) {
 			resultrocStates = append(resultrocStates, proc)
 		}
 	}
 	count := int64(len(resultrocStates))
-	msg := gatherMsg(count)
+	msg := opts.gatherMsg(count)
+
+	critical := opts.CritUnder != 0 && count < opts.CritUnder ||
+		opts.CritOver != nil && count > *opts.CritOver
+	if opts.CritRange.IsSet() {
+		critical = opts.CritRange.Alert(float64(count))
+	}
+	warning := opts.WarnUnder != 0 && count < opts.WarnUnder ||
+		opts.WarnOver != nil && count > *opts.WarnOver
+	if opts.WarnRange.IsSet() {
+		warning = opts.WarnRange.Alert(float64(count))
+	}
+
 	result := checkers.OK
-	if opts.CritUnder != 0 && count < opts.CritUnder ||
-		opts.CritOver != nil && count > *opts.CritOver {
+	if critical {
 		result = checkers.CRITICAL
-	} else if opts.WarnUnder != 0 && count < opts.WarnUnder ||
-		opts.WarnOver != nil && count > *opts.WarnOver {
+	} else if warning {
 		result = checkers.WARNING
 	}
 	return checkers.NewChecker(result, msg)
 }
 
-func matchProc(proc procState, cmdPatRegexp *regexp.Regexp, cmdExcludePatRegexp *regexp.Regexp) bool {
+func (opts *procsOpts) matchProc(proc procState, cmdPatRegexp *regexp.Regexp, cmdExcludePatRegexp *regexp.Regexp) bool {
 	return (opts.CmdPat == "" || cmdPatRegexp.MatchString(proc.cmd)) &&
 		(opts.CmdExcludePat == "" || !cmdExcludePatRegexp.MatchString(proc.cmd)) &&
 		(opts.MatchSelf || proc.pid != strconv.Itoa(os.Getpid())) &&
@@ -116,7 +130,7 @@ func matchProc(proc procState, cmdPatRegexp *regexp.Regexp, cmdExcludePatRegexp
 		(opts.CPUOver == 0 || proc.csec > opts.CPUOver)
 }
 
-func gatherMsg(count int64) string {
+func (opts *procsOpts) gatherMsg(count int64) string {
 	msg := fmt.Sprintf("Found %d matching processes", count)
 	if opts.CmdPat != "" {
 		msg += fmt.Sprintf("; cmd /%s/", opts.CmdPat)
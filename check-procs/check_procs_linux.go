@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// clockTicks is USER_HZ, the unit of times in /proc/[pid]/stat
+const clockTicks = 100
+
+func getProcs() (proc []procState, err error) {
+	var procs []procState
+	uptime, err := readUptime()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[uint32]string)
+	for _, dir := range dirs {
+		pid := dir.Name()
+		if _, err := strconv.Atoi(pid); err != nil || !dir.IsDir() {
+			continue
+		}
+		proc, err := readProc(pid, uptime, users)
+		if err != nil {
+			// the process has exited
+			continue
+		}
+		procs = append(procs, proc)
+	}
+	return procs, nil
+}
+
+func readUptime() (float64, error) {
+	b, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, errors.New("readUptime: empty /proc/uptime")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+func readProc(pid string, uptime float64, users map[uint32]string) (procState, error) {
+	dir := filepath.Join("/proc", pid)
+	stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return procState{}, err
+	}
+	proc, comm, err := parseStat(string(stat), uptime)
+	if err != nil {
+		return procState{}, err
+	}
+	proc.pid = pid
+
+	cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return procState{}, err
+	}
+	proc.cmd = strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))
+	if proc.cmd == "" {
+		// kernel threads have no command line
+		proc.cmd = "[" + comm + "]"
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return procState{}, err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		name, ok := users[st.Uid]
+		if !ok {
+			name = strconv.FormatUint(uint64(st.Uid), 10)
+			if u, err := user.LookupId(name); err == nil {
+				name = u.Username
+			}
+			users[st.Uid] = name
+		}
+		proc.user = name
+	}
+	return proc, nil
+}
+
+// parseStat parses the content of /proc/[pid]/stat and returns the process
+// state other than pid, cmd and user, and the command name
+func parseStat(stat string, uptime float64) (procState, string, error) {
+	// the command name is enclosed in parentheses and may contain spaces
+	lp := strings.IndexByte(stat, '(')
+	rp := strings.LastIndexByte(stat, ')')
+	if lp < 0 || rp < lp {
+		return procState{}, "", errors.New("parseStat: no command name")
+	}
+	comm := stat[lp+1 : rp]
+	// fields from the third one (state)
+	fields := strings.Fields(stat[rp+1:])
+	if len(fields) < 22 {
+		return procState{}, "", errors.New("parseStat: insufficient words")
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	thcount, _ := strconv.ParseInt(fields[17], 10, 64)
+	starttime, _ := strconv.ParseInt(fields[19], 10, 64)
+	vsize, _ := strconv.ParseInt(fields[20], 10, 64)
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+
+	esec := int64(uptime) - starttime/clockTicks
+	csec := (utime + stime) / clockTicks
+	pcpu := 0.0
+	if elapsed := uptime - float64(starttime)/clockTicks; elapsed > 0 {
+		pcpu = float64(utime+stime) / clockTicks / elapsed * 100
+	}
+	return procState{
+		ppid: fields[1],
+		// vsz and rss are in KiB as ps reports
+		vsz:     vsize / 1024,
+		rss:     rss * int64(os.Getpagesize()) / 1024,
+		pcpu:    pcpu,
+		thcount: thcount,
+		state:   fields[0],
+		esec:    esec,
+		csec:    csec,
+	}, comm, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStat(t *testing.T) {
+	stat := "1234 (nginx: worker) S 1200 1200 1200 0 -1 4194368 1205 0 0 0 250 150 0 0 20 0 4 0 10000 104857600 2560 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 1 0 0 0 0 0"
+	proc, comm, err := parseStat(stat, 300)
+	assert.Equal(t, nil, err, "err should be nil")
+	assert.Equal(t, "nginx: worker", comm, "command name may contain spaces")
+	assert.Equal(t, "1200", proc.ppid, "ppid")
+	assert.Equal(t, "S", proc.state, "state")
+	assert.Equal(t, int64(4), proc.thcount, "thcount")
+	assert.Equal(t, int64(102400), proc.vsz, "vsz in KiB")
+	assert.Equal(t, int64(2560*os.Getpagesize()/1024), proc.rss, "rss in KiB")
+	assert.Equal(t, int64(200), proc.esec, "esec")
+	assert.Equal(t, int64(4), proc.csec, "csec")
+	assert.Equal(t, 2.0, proc.pcpu, "pcpu")
+
+	_, _, err = parseStat("1234 (short) S 1", 300)
+	assert.NotNil(t, err, "insufficient words")
+}
+
+func TestGetProcs(t *testing.T) {
+	procs, err := getProcs()
+	assert.Equal(t, nil, err, "err should be nil")
+	found := false
+	for _, proc := range procs {
+		if proc.pid == strconv.Itoa(os.Getpid()) {
+			found = true
+			assert.Equal(t, strconv.Itoa(os.Getppid()), proc.ppid, "ppid")
+		}
+	}
+	assert.True(t, found, "should find itself")
+}
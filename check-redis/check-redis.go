@@ -1,23 +1,34 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/fzzy/radix/redis"
 	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 type redisSetting struct {
-	Host    string `short:"H" long:"host" default:"localhost" description:"Hostname"`
-	Socket  string `short:"s" long:"socket" default:"" description:"Server socket"`
-	Port    string `short:"p" long:"port" default:"6379" description:"Port"`
-	Timeout uint64 `short:"t" long:"timeout" default:"5" description:"Dial Timeout in sec"`
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Host   string `short:"H" long:"host" default:"localhost" description:"Hostname"`
+	Socket string `short:"s" long:"socket" default:"" description:"Server socket"`
+	Port   string `short:"p" long:"port" default:"6379" description:"Port"`
+
+	Password           string `short:"a" long:"password" default:"" description:"Password to send with AUTH"`
+	TLS                bool   `long:"tls" description:"Connect with TLS"`
+	TLSCAFile          string `long:"tls-ca-file" description:"CA certificate file to verify the server certificate"`
+	NoCheckCertificate bool   `long:"no-check-certificate" description:"Do not check certificate"`
 }
 
 var commands = map[string](func([]string) *checkers.Checker){
@@ -45,33 +56,79 @@ SubCommands:`)
 		}
 		os.Exit(1)
 	}
-	ckr := fn(argv)
-	ckr.Name = fmt.Sprintf("Redis %s", strings.ToUpper(string(subCmd[0]))+subCmd[1:])
-	ckr.Exit()
+	name := fmt.Sprintf("Redis %s", strings.ToUpper(string(subCmd[0]))+subCmd[1:])
+	pluginutil.Main(name, func([]string) *checkers.Checker {
+		return fn(argv)
+	})
+}
+
+// customizeParser keeps the default timeout of 5 seconds, which is shorter
+// than the other plugins
+func customizeParser(p *flags.Parser, usage string) {
+	p.Usage = usage
+	pluginutil.SetDefault(p, "timeout", "5")
 }
 
-func connectRedis(m redisSetting) (*redis.Client, error) {
+func (m redisSetting) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         m.Host,
+		InsecureSkipVerify: m.NoCheckCertificate,
+	}
+	if m.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(m.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", m.TLSCAFile)
+		}
+	}
+	return config, nil
+}
+
+func connectRedis(m redisSetting) (*respConn, error) {
 	network := "tcp"
-	target := fmt.Sprintf("%s:%s", m.Host, m.Port)
+	target := net.JoinHostPort(m.Host, m.Port)
 	if m.Socket != "" {
 		target = m.Socket
 		network = "unix"
 	}
-	c, err := redis.DialTimeout(network, target, time.Duration(m.Timeout)*time.Second)
+	timeout := m.TimeoutDuration()
+	conn, err := net.DialTimeout(network, target, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't connect: %s", err)
 	}
+	if m.TLS {
+		config, err := m.tlsConfig()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, config)
+		if timeout > 0 {
+			tlsConn.SetDeadline(time.Now().Add(timeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("couldn't connect: %s", err)
+		}
+		conn = tlsConn
+	}
+	c := newRespConn(conn, timeout)
+	if m.Password != "" {
+		if _, err := c.do("AUTH", m.Password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("couldn't authenticate: %s", err)
+		}
+	}
 	return c, nil
 }
 
-func getRedisInfo(c *redis.Client) (*map[string]string, error) {
+func getRedisInfo(c *respConn) (*map[string]string, error) {
 	info := make(map[string]string)
 
-	r := c.Cmd("info")
-	if r.Err != nil {
-		return nil, errors.New("couldn't execute query")
-	}
-	str, err := r.Str()
+	str, err := c.str("INFO")
 	if err != nil {
 		return nil, errors.New("couldn't execute query")
 	}
@@ -95,7 +152,7 @@ func getRedisInfo(c *redis.Client) (*map[string]string, error) {
 	return &info, nil
 }
 
-func connectRedisGetInfo(opts redisSetting) (*redis.Client, *map[string]string, error) {
+func connectRedisGetInfo(opts redisSetting) (*respConn, *map[string]string, error) {
 	c, err := connectRedis(opts)
 	if err != nil {
 		return nil, nil, err
@@ -103,50 +160,86 @@ func connectRedisGetInfo(opts redisSetting) (*redis.Client, *map[string]string,
 
 	info, err := getRedisInfo(c)
 	if err != nil {
+		c.Close()
 		return nil, nil, err
 	}
 
 	return c, info, nil
 }
 
+type reachableOpts struct {
+	redisSetting
+	ExpectedRole string `long:"expected-role" description:"Expected role of the server, master or slave"`
+}
+
 func checkReachable(args []string) *checkers.Checker {
-	opts := redisSetting{}
-	psr := flags.NewParser(&opts, flags.Default)
-	psr.Usage = "reachable [OPTIONS]"
-	_, err := psr.ParseArgs(args)
+	return pluginutil.Run(&reachableOpts{}, args)
+}
+
+func (opts *reachableOpts) CustomizeParser(p *flags.Parser) {
+	customizeParser(p, "reachable [OPTIONS]")
+}
+
+func (opts *reachableOpts) Run() *checkers.Checker {
+	if opts.ExpectedRole != "" && opts.ExpectedRole != "master" && opts.ExpectedRole != "slave" {
+		return checkers.Unknown(fmt.Sprintf("invalid expected-role: %s", opts.ExpectedRole))
+	}
+
+	c, err := connectRedis(opts.redisSetting)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return pluginutil.Failure(checkers.Unknown(err.Error()))
+	}
+	defer c.Close()
+
+	if pong, err := c.str("PING"); err != nil || pong != "PONG" {
+		return checkers.Critical(fmt.Sprintf("PING failed: %v", errOrReply(err, pong)))
 	}
 
-	c, info, err := connectRedisGetInfo(opts)
+	info, err := getRedisInfo(c)
 	if err != nil {
 		return checkers.Unknown(err.Error())
 	}
-	defer c.Close()
 
 	if _, ok := (*info)["redis_version"]; !ok {
 		return checkers.Unknown("couldn't get redis_version")
 	}
 
-	return checkers.Ok(
-		fmt.Sprintf("version: %s", (*info)["redis_version"]),
-	)
+	msg := fmt.Sprintf("version: %s", (*info)["redis_version"])
+	if opts.ExpectedRole != "" {
+		role := (*info)["role"]
+		if role != opts.ExpectedRole {
+			return checkers.Critical(fmt.Sprintf("role: %s (expected %s)", role, opts.ExpectedRole))
+		}
+		msg += fmt.Sprintf(", role: %s", role)
+	}
+	return checkers.Ok(msg)
 }
 
-func checkSlave(args []string) *checkers.Checker {
-	opts := redisSetting{}
-	psr := flags.NewParser(&opts, flags.Default)
-	psr.Usage = "slave [OPTIONS]"
-	_, err := psr.ParseArgs(args)
+func errOrReply(err error, reply string) interface{} {
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
+	return reply
+}
 
-	c, info, err := connectRedisGetInfo(opts)
+type slaveOpts struct {
+	redisSetting
+	Warn int64 `short:"w" long:"warning" default:"0" description:"warning if the seconds since the last interaction with master is over"`
+	Crit int64 `short:"c" long:"critical" default:"0" description:"critical if the seconds since the last interaction with master is over"`
+}
+
+func checkSlave(args []string) *checkers.Checker {
+	return pluginutil.Run(&slaveOpts{}, args)
+}
+
+func (opts *slaveOpts) CustomizeParser(p *flags.Parser) {
+	customizeParser(p, "slave [OPTIONS]")
+}
+
+func (opts *slaveOpts) Run() *checkers.Checker {
+	c, info, err := connectRedisGetInfo(opts.redisSetting)
 	if err != nil {
-		return checkers.Unknown(err.Error())
+		return pluginutil.Failure(checkers.Unknown(err.Error()))
 	}
 	defer c.Close()
 
@@ -155,8 +248,11 @@ func checkSlave(args []string) *checkers.Checker {
 
 		switch status {
 		case "up":
-			return checkers.Ok(msg)
+			return checkLag(*info, *opts, msg)
 		case "down":
+			if since, ok := (*info)["master_link_down_since_seconds"]; ok {
+				msg += fmt.Sprintf(", master_link_down_since_seconds: %s", since)
+			}
 			return checkers.Critical(msg)
 		default:
 			return checkers.Unknown(msg)
@@ -167,3 +263,24 @@ func checkSlave(args []string) *checkers.Checker {
 		return checkers.Unknown("couldn't get master_link_status")
 	}
 }
+
+// checkLag checks master_last_io_seconds_ago, the seconds since the slave
+// received anything from the master, which pings every 10 seconds by default
+func checkLag(info map[string]string, opts slaveOpts, msg string) *checkers.Checker {
+	v, ok := info["master_last_io_seconds_ago"]
+	if !ok {
+		return checkers.Ok(msg)
+	}
+	lag, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return checkers.Unknown(fmt.Sprintf("invalid master_last_io_seconds_ago: %s", v))
+	}
+	msg += fmt.Sprintf(", master_last_io_seconds_ago: %d", lag)
+	checkSt := checkers.OK
+	if opts.Crit > 0 && lag > opts.Crit {
+		checkSt = checkers.CRITICAL
+	} else if opts.Warn > 0 && lag > opts.Warn {
+		checkSt = checkers.WARNING
+	}
+	return checkers.NewChecker(checkSt, msg)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveRedis starts a server which replies to AUTH, PING and INFO with the
+// info, requiring the password when it is not empty
+func serveRedis(t *testing.T, password, info string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go acceptRedis(l, password, info)
+	return l
+}
+
+// serveRedisTLS is the same as serveRedis, but over TLS
+func serveRedisTLS(t *testing.T, password, info string) net.Listener {
+	ts := httptest.NewTLSServer(nil)
+	config := ts.TLS
+	ts.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go acceptRedis(l, password, info)
+	return l
+}
+
+func acceptRedis(l net.Listener, password, info string) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			r := bufio.NewReader(c)
+			authed := password == ""
+			for {
+				args, err := readCommand(r)
+				if err != nil {
+					return
+				}
+				switch cmd := strings.ToUpper(args[0]); {
+				case cmd == "AUTH":
+					if len(args) == 2 && args[1] == password {
+						authed = true
+						fmt.Fprint(c, "+OK\r\n")
+					} else {
+						fmt.Fprint(c, "-ERR invalid password\r\n")
+					}
+				case !authed:
+					fmt.Fprint(c, "-NOAUTH Authentication required.\r\n")
+				case cmd == "PING":
+					fmt.Fprint(c, "+PONG\r\n")
+				case cmd == "INFO":
+					fmt.Fprintf(c, "$%d\r\n%s\r\n", len(info), info)
+				default:
+					fmt.Fprintf(c, "-ERR unknown command '%s'\r\n", args[0])
+				}
+			}
+		}(c)
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+const masterInfo = "# Server\r\nredis_version:3.2.0\r\n\r\n# Replication\r\nrole:master\r\nconnected_slaves:1\r\n"
+
+const slaveInfo = "# Server\r\nredis_version:3.2.0\r\n\r\n# Replication\r\nrole:slave\r\nmaster_host:10.0.0.1\r\nmaster_port:6379\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:8\r\n"
+
+func TestReachable(t *testing.T) {
+	l := serveRedis(t, "secret", masterInfo)
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testAuth := func() {
+		ckr := checkReachable([]string{"-H", "127.0.0.1", "-p", port, "-a", "secret"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "version: 3.2.0", ckr.Message, "something went wrong")
+	}
+	testAuth()
+
+	testWrongPassword := func() {
+		ckr := checkReachable([]string{"-H", "127.0.0.1", "-p", port, "-a", "wrong"})
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "couldn't authenticate: ERR invalid password", ckr.Message, "something went wrong")
+	}
+	testWrongPassword()
+
+	testNoAuth := func() {
+		ckr := checkReachable([]string{"-H", "127.0.0.1", "-p", port})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "PING failed: NOAUTH Authentication required.", ckr.Message, "something went wrong")
+	}
+	testNoAuth()
+
+	testExpectedRole := func() {
+		ckr := checkReachable([]string{"-H", "127.0.0.1", "-p", port, "-a", "secret", "--expected-role", "master"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "version: 3.2.0, role: master", ckr.Message, "something went wrong")
+
+		ckr = checkReachable([]string{"-H", "127.0.0.1", "-p", port, "-a", "secret", "--expected-role", "slave"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "role: master (expected slave)", ckr.Message, "something went wrong")
+	}
+	testExpectedRole()
+}
+
+func TestSlave(t *testing.T) {
+	l := serveRedis(t, "", slaveInfo)
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	ckr := checkSlave([]string{"-H", "127.0.0.1", "-p", port})
+	assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	assert.Equal(t, "master_link_status: up, master_last_io_seconds_ago: 8", ckr.Message, "something went wrong")
+
+	ckr = checkSlave([]string{"-H", "127.0.0.1", "-p", port, "-w", "5", "-c", "10"})
+	assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+
+	ckr = checkSlave([]string{"-H", "127.0.0.1", "-p", port, "-w", "3", "-c", "5"})
+	assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+
+	m := serveRedis(t, "", masterInfo)
+	defer m.Close()
+	_, port, _ = net.SplitHostPort(m.Addr().String())
+	ckr = checkSlave([]string{"-H", "127.0.0.1", "-p", port})
+	assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+}
+
+func TestTimeout(t *testing.T) {
+	opts := &slaveOpts{}
+	err := pluginutil.Parse(opts, []string{})
+	assert.Nil(t, err, "something went wrong")
+	assert.Equal(t, float64(5), opts.Timeout, "something went wrong")
+
+	opts = &slaveOpts{}
+	err = pluginutil.Parse(opts, []string{"-t", "0.5"})
+	assert.Nil(t, err, "something went wrong")
+	assert.Equal(t, "500ms", opts.TimeoutDuration().String(), "something went wrong")
+}
+
+func TestTLSWithoutTimeout(t *testing.T) {
+	l := serveRedisTLS(t, "", masterInfo)
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	ckr := checkReachable([]string{"-H", "127.0.0.1", "-p", port, "--tls", "--no-check-certificate", "-t", "0"})
+	assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	assert.Equal(t, "version: 3.2.0", ckr.Message, "something went wrong")
+}
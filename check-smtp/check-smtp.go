@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type smtpOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Host               string   `short:"H" long:"host" default:"localhost" description:"Host name or IP Address"`
+	Port               int      `short:"p" long:"port" default:"25" description:"Port number"`
+	Ehlo               string   `short:"e" long:"ehlo" description:"Host name to send with EHLO (default: local host name)"`
+	StartTLS           bool     `long:"starttls" description:"Upgrade the connection with STARTTLS"`
+	SSL                bool     `short:"S" long:"ssl" description:"Use SSL for the connection (SMTPS)"`
+	NoCheckCertificate bool     `long:"no-check-certificate" description:"Do not check certificate"`
+	AuthMech           string   `short:"A" long:"auth-mech" default:"PLAIN" description:"SASL mechanism to authenticate with. PLAIN or LOGIN"`
+	User               string   `short:"U" long:"user" description:"User name to authenticate with"`
+	Password           string   `short:"P" long:"password" description:"Password to authenticate with"`
+	From               string   `short:"f" long:"from" description:"Envelope sender to try with MAIL FROM"`
+	Rcpt               []string `short:"r" long:"rcpt" description:"Envelope recipient to try with RCPT TO (multiple -r options are allowed)"`
+	Warning            float64  `short:"w" long:"warning" description:"Response time to result in warning status (seconds)"`
+	Critical           float64  `short:"c" long:"critical" description:"Response time to result in critical status (seconds)"`
+}
+
+func main() {
+	pluginutil.Main("SMTP", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&smtpOpts{}, args)
+}
+
+// step is a command of the dialog and its latency
+type step struct {
+	name    string
+	elapsed time.Duration
+}
+
+// stepError is the failure of a command of the dialog
+type stepError struct {
+	name string
+	err  error
+}
+
+func (e *stepError) Error() string {
+	// format the reply by ourselves as textproto.Error quotes the message
+	if reply, ok := e.err.(*textproto.Error); ok {
+		return fmt.Sprintf("%s failed: %03d %s", e.name, reply.Code, reply.Msg)
+	}
+	return fmt.Sprintf("%s failed: %s", e.name, e.err)
+}
+
+func (opts *smtpOpts) prepare() error {
+	if opts.StartTLS && opts.SSL {
+		return errors.New("--starttls can't be used with --ssl")
+	}
+	if (opts.User == "") != (opts.Password == "") {
+		return errors.New("--user and --password must be specified together")
+	}
+	switch strings.ToUpper(opts.AuthMech) {
+	case "PLAIN", "LOGIN":
+	default:
+		return fmt.Errorf("unsupported auth-mech: %s", opts.AuthMech)
+	}
+	if len(opts.Rcpt) > 0 && opts.From == "" {
+		return errors.New("--rcpt requires --from")
+	}
+	if opts.Ehlo == "" {
+		name, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		opts.Ehlo = name
+	}
+	return nil
+}
+
+func (opts *smtpOpts) auth() smtp.Auth {
+	if strings.ToUpper(opts.AuthMech) == "LOGIN" {
+		return &loginAuth{opts.User, opts.Password, opts.Host}
+	}
+	return smtp.PlainAuth("", opts.User, opts.Password, opts.Host)
+}
+
+// dialog runs the SMTP dialog and records the latency of each command. It
+// never sends DATA, so that no mail is delivered.
+func (opts *smtpOpts) dialog() (steps []step, err error) {
+	timeout := opts.TimeoutDuration()
+	tlsConfig := &tls.Config{
+		ServerName:         opts.Host,
+		InsecureSkipVerify: opts.NoCheckCertificate,
+	}
+	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+
+	do := func(name string, f func() error) error {
+		start := time.Now()
+		if err := f(); err != nil {
+			return &stepError{name, err}
+		}
+		steps = append(steps, step{name, time.Since(start)})
+		return nil
+	}
+
+	var c *smtp.Client
+	err = do("connect", func() error {
+		d := &net.Dialer{Timeout: timeout}
+		var conn net.Conn
+		var err error
+		if opts.SSL {
+			conn, err = tls.DialWithDialer(d, "tcp", address, tlsConfig)
+		} else {
+			conn, err = d.Dial("tcp", address)
+		}
+		if err != nil {
+			return err
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		// NewClient reads the greeting
+		c, err = smtp.NewClient(conn, opts.Host)
+		if err != nil {
+			conn.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return steps, err
+	}
+	defer c.Close()
+
+	if err := do("ehlo", func() error { return c.Hello(opts.Ehlo) }); err != nil {
+		return steps, err
+	}
+	if opts.StartTLS {
+		if err := do("starttls", func() error { return c.StartTLS(tlsConfig) }); err != nil {
+			return steps, err
+		}
+	}
+	if opts.User != "" {
+		if err := do("auth", func() error { return c.Auth(opts.auth()) }); err != nil {
+			return steps, err
+		}
+	}
+	if opts.From != "" {
+		if err := do("mail", func() error { return c.Mail(opts.From) }); err != nil {
+			return steps, err
+		}
+		for _, rcpt := range opts.Rcpt {
+			if err := do("rcpt", func() error { return c.Rcpt(rcpt) }); err != nil {
+				return steps, err
+			}
+		}
+		if err := do("rset", c.Reset); err != nil {
+			return steps, err
+		}
+	}
+	err = do("quit", c.Quit)
+	return steps, err
+}
+
+func (opts *smtpOpts) Run() *checkers.Checker {
+	if err := opts.prepare(); err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	steps, err := opts.dialog()
+	if err != nil {
+		ckr := checkers.Critical(err.Error())
+		if se, ok := err.(*stepError); ok && se.name == "connect" {
+			return pluginutil.Failure(ckr)
+		}
+		return ckr
+	}
+
+	var total time.Duration
+	var latencies []string
+	for _, s := range steps {
+		total += s.elapsed
+		latencies = append(latencies, fmt.Sprintf("%s %.3f", s.name, s.elapsed.Seconds()))
+	}
+	chkSt := checkers.OK
+	if opts.Critical > 0 && total.Seconds() > opts.Critical {
+		chkSt = checkers.CRITICAL
+	} else if opts.Warning > 0 && total.Seconds() > opts.Warning {
+		chkSt = checkers.WARNING
+	}
+	msg := fmt.Sprintf("%.3f seconds response time on %s port %d [%s]", total.Seconds(), opts.Host, opts.Port, strings.Join(latencies, ", "))
+	return checkers.NewChecker(chkSt, msg)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSMTP starts an SMTP server which accepts user "monitor" with password
+// "secret" and recipients of example.com
+func serveSMTP(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				reply := func(s string) { fmt.Fprint(c, s+"\r\n") }
+				readLine := func() string {
+					line, _ := r.ReadString('\n')
+					return strings.TrimRight(line, "\r\n")
+				}
+				decode := func(s string) string {
+					b, _ := base64.StdEncoding.DecodeString(s)
+					return string(b)
+				}
+				reply("220 mail.example.com ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					cmd := strings.TrimRight(line, "\r\n")
+					switch {
+					case strings.HasPrefix(cmd, "EHLO "):
+						reply("250-mail.example.com\r\n250 AUTH PLAIN LOGIN")
+					case strings.HasPrefix(cmd, "AUTH PLAIN "):
+						if decode(strings.TrimPrefix(cmd, "AUTH PLAIN ")) == "\x00monitor\x00secret" {
+							reply("235 2.7.0 Authentication successful")
+						} else {
+							reply("535 5.7.8 Authentication credentials invalid")
+						}
+					case cmd == "AUTH LOGIN":
+						reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+						user := decode(readLine())
+						reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+						pass := decode(readLine())
+						if user == "monitor" && pass == "secret" {
+							reply("235 2.7.0 Authentication successful")
+						} else {
+							reply("535 5.7.8 Authentication credentials invalid")
+						}
+					case strings.HasPrefix(cmd, "MAIL FROM:"):
+						reply("250 2.1.0 Ok")
+					case strings.HasPrefix(cmd, "RCPT TO:"):
+						if strings.HasSuffix(cmd, "@example.com>") {
+							reply("250 2.1.5 Ok")
+						} else {
+							reply("554 5.7.1 Relay access denied")
+						}
+					case cmd == "RSET":
+						reply("250 2.0.0 Ok")
+					case cmd == "QUIT":
+						reply("221 2.0.0 Bye")
+						return
+					default:
+						reply("502 5.5.2 Error: command not recognized")
+					}
+				}
+			}(c)
+		}
+	}()
+	return l
+}
+
+func TestRun(t *testing.T) {
+	l := serveSMTP(t)
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testGreeting := func() {
+		ckr := run([]string{"-H", host, "-p", port})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `^\d+\.\d{3} seconds response time on 127\.0\.0\.1 port \d+ \[connect \d+\.\d{3}, ehlo \d+\.\d{3}, quit \d+\.\d{3}\]$`, ckr.Message, "Unexpected response")
+	}
+	testGreeting()
+
+	testDialog := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-U", "monitor", "-P", "secret", "-f", "monitor@example.net", "-r", "postmaster@example.com"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[connect .+, ehlo .+, auth .+, mail .+, rcpt .+, rset .+, quit .+\]$`, ckr.Message, "Unexpected response")
+	}
+	testDialog()
+
+	testLogin := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-A", "login", "-U", "monitor", "-P", "secret"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testLogin()
+
+	testAuthFailure := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-U", "monitor", "-P", "wrong"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "auth failed: 535 5.7.8 Authentication credentials invalid", ckr.Message, "Unexpected response")
+	}
+	testAuthFailure()
+
+	testRelayDenied := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-f", "monitor@example.net", "-r", "someone@example.org"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "rcpt failed: 554 5.7.1 Relay access denied", ckr.Message, "Unexpected response")
+	}
+	testRelayDenied()
+
+	testInvalidOptions := func() {
+		ckr := run([]string{"-H", host, "-p", port, "-r", "postmaster@example.com"})
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "--rcpt requires --from", ckr.Message, "Unexpected response")
+	}
+	testInvalidOptions()
+}
@@ -5,11 +5,13 @@ import (
 	"os"
 	"strings"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
 type solrOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
 	Host string `short:"H" long:"host" default:"localhost" description:"Hostname"`
 	Port string `short:"p" long:"port" default:"8983" description:"Port"`
 	Core string `short:"c" long:"core" required:"true" description:"Core"`
@@ -19,7 +21,7 @@ func (s solrOpts) createBaseURL() string {
 	return fmt.Sprintf("http://%s:%s/solr/%s", s.Host, s.Port, s.Core)
 }
 
-var commands = map[string](func(solrOpts) *checkers.Checker){
+var commands = map[string](func([]string) *checkers.Checker){
 	"ping": checkPing,
 }
 
@@ -43,16 +45,8 @@ SubCommands:`)
 		}
 		os.Exit(1)
 	}
-
-	opts := solrOpts{}
-	psr := flags.NewParser(&opts, flags.Default)
-	psr.Usage = fmt.Sprintf("%s [OPTIONS]", subCmd)
-	_, err := psr.ParseArgs(argv)
-	if err != nil {
-		os.Exit(1)
-	}
-
-	ckr := fn(opts)
-	ckr.Name = fmt.Sprintf("Solr %s", strings.Title(subCmd))
-	ckr.Exit()
+	name := fmt.Sprintf("Solr %s", strings.Title(subCmd))
+	pluginutil.Main(name, func([]string) *checkers.Checker {
+		return fn(argv)
+	})
 }
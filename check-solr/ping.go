@@ -5,14 +5,29 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 )
 
-func checkPing(opts solrOpts) *checkers.Checker {
+type pingOpts struct {
+	solrOpts
+}
+
+func checkPing(args []string) *checkers.Checker {
+	return pluginutil.Run(&pingOpts{}, args)
+}
+
+func (opts *pingOpts) CustomizeParser(p *flags.Parser) {
+	p.Usage = "ping [OPTIONS]"
+}
+
+func (opts *pingOpts) Run() *checkers.Checker {
 	uri := opts.createBaseURL() + "/admin/ping?wt=json"
-	resp, err := http.Get(uri)
+	client := &http.Client{Timeout: opts.TimeoutDuration()}
+	resp, err := client.Get(uri)
 	if err != nil {
-		return checkers.Unknown("couldn't get access to " + uri)
+		return pluginutil.Failure(checkers.Unknown("couldn't get access to " + uri))
 	}
 	defer resp.Body.Close()
 
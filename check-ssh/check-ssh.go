@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"golang.org/x/crypto/ssh"
+)
+
+type sshOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Host           string  `short:"H" long:"host" default:"localhost" description:"Host name or IP Address"`
+	Port           int     `short:"p" long:"port" default:"22" description:"Port number"`
+	Warning        float64 `short:"w" long:"warning" description:"Response time to result in warning status (seconds)"`
+	Critical       float64 `short:"c" long:"critical" description:"Response time to result in critical status (seconds)"`
+	User           string  `short:"u" long:"user" description:"User name to authenticate with. Only the key exchange is checked without it"`
+	Identity       string  `short:"i" long:"identity" value-name:"FILE" description:"Private key file to authenticate with"`
+	RemoteVersion  string  `short:"r" long:"remote-version" description:"Software version the server should advertise (e.g. OpenSSH_7.4)"`
+	RemoteProtocol string  `short:"P" long:"remote-protocol" description:"Protocol version the server should advertise (e.g. 2.0)"`
+}
+
+func main() {
+	pluginutil.Main("SSH", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&sshOpts{}, args)
+}
+
+// bannerConn records what the server sends until its version line, which
+// ssh.Conn exposes only after the handshake has succeeded
+type bannerConn struct {
+	net.Conn
+	buf  []byte
+	done bool
+}
+
+func (c *bannerConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.done {
+		c.buf = append(c.buf, p[:n]...)
+		c.done = c.version() != "" || len(c.buf) > 8192
+	}
+	return n, err
+}
+
+// version returns the version line of the server. It may be preceded by
+// other lines.
+func (c *bannerConn) version() string {
+	for _, line := range bytes.SplitAfter(c.buf, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("SSH-")) && bytes.HasSuffix(line, []byte("\n")) {
+			return strings.TrimRight(string(line), "\r\n")
+		}
+	}
+	return ""
+}
+
+// parseVersion parses the version line, SSH-protoversion-softwareversion
+// followed by optional comments
+func parseVersion(line string) (protocol, software string, err error) {
+	fields := strings.SplitN(strings.TrimPrefix(line, "SSH-"), "-", 2)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("invalid version: %s", line)
+	}
+	return fields[0], strings.SplitN(fields[1], " ", 2)[0], nil
+}
+
+func (opts *sshOpts) authMethods() ([]ssh.AuthMethod, error) {
+	if opts.User == "" {
+		return nil, nil
+	}
+	if opts.Identity == "" {
+		return nil, errors.New("--user requires --identity")
+	}
+	pem, err := ioutil.ReadFile(opts.Identity)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, err
+	}
+	return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+}
+
+func (opts *sshOpts) Run() *checkers.Checker {
+	auth, err := opts.authMethods()
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	timeout := opts.TimeoutDuration()
+	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return pluginutil.Failure(checkers.Critical(err.Error()))
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(timeout))
+	bc := &bannerConn{Conn: conn}
+
+	// the host key is not verified as the key exchange itself is the target
+	var kexDone time.Duration
+	config := &ssh.ClientConfig{
+		User: opts.User,
+		Auth: auth,
+		HostKeyCallback: func(string, net.Addr, ssh.PublicKey) error {
+			kexDone = time.Since(start)
+			return nil
+		},
+	}
+	c, chans, reqs, err := ssh.NewClientConn(bc, address, config)
+	elapsed := time.Since(start)
+	if err == nil {
+		ssh.NewClient(c, chans, reqs).Close()
+	}
+
+	version := bc.version()
+	if version == "" {
+		if err == nil {
+			err = errors.New("no version")
+		}
+		return pluginutil.Failure(checkers.Critical(fmt.Sprintf("no SSH version from server: %s", err)))
+	}
+	protocol, software, perr := parseVersion(version)
+	if perr != nil {
+		return checkers.Critical(perr.Error())
+	}
+	if kexDone == 0 {
+		return checkers.Critical(fmt.Sprintf("key exchange failed with %s: %s", software, err))
+	}
+	if err != nil && auth != nil {
+		return checkers.Critical(fmt.Sprintf("authentication failed with %s: %s", software, err))
+	}
+	// without credentials, the authentication fails after the key exchange
+
+	if opts.RemoteProtocol != "" && protocol != opts.RemoteProtocol {
+		return checkers.Critical(fmt.Sprintf("protocol version %s (expected %s)", protocol, opts.RemoteProtocol))
+	}
+	if opts.RemoteVersion != "" && software != opts.RemoteVersion {
+		return checkers.Critical(fmt.Sprintf("software version %s (expected %s)", software, opts.RemoteVersion))
+	}
+
+	if auth == nil {
+		elapsed = kexDone
+	}
+	chkSt := checkers.OK
+	if opts.Critical > 0 && elapsed.Seconds() > opts.Critical {
+		chkSt = checkers.CRITICAL
+	} else if opts.Warning > 0 && elapsed.Seconds() > opts.Warning {
+		chkSt = checkers.WARNING
+	}
+	msg := fmt.Sprintf("%s (protocol %s) - %.3f seconds response time", software, protocol, elapsed.Seconds())
+	if auth != nil {
+		msg += fmt.Sprintf(" [kex %.3f, auth %.3f]", kexDone.Seconds(), (elapsed - kexDone).Seconds())
+	}
+	return checkers.NewChecker(chkSt, msg)
+}
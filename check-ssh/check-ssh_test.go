@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// serveSSH starts an SSH server which accepts the public key of the user
+// "monitor" and closes connections after the authentication
+func serveSSH(t *testing.T, authorized ssh.PublicKey) net.Listener {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		ServerVersion: "SSH-2.0-OpenSSH_7.4 Debian",
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "monitor" && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", meta.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				ssh.NewServerConn(c, config)
+			}(c)
+		}
+	}()
+	return l
+}
+
+// serveBanner starts a server which sends the version but never completes
+// the key exchange as a half-broken sshd
+func serveBanner(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		var conns []net.Conn
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			fmt.Fprint(c, "SSH-2.0-OpenSSH_7.4\r\n")
+			conns = append(conns, c)
+		}
+	}()
+	return l
+}
+
+func writeKey(t *testing.T, dir string) ssh.PublicKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "id_ed25519"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	identity := filepath.Join(dir, "id_ed25519")
+
+	l := serveSSH(t, writeKey(t, dir))
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testKex := func() {
+		ckr := run([]string{"-H", "127.0.0.1", "-p", port})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `^OpenSSH_7\.4 \(protocol 2\.0\) - \d+\.\d{3} seconds response time$`, ckr.Message, "something went wrong")
+	}
+	testKex()
+
+	testAuth := func() {
+		ckr := run([]string{"-H", "127.0.0.1", "-p", port, "-u", "monitor", "-i", identity})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `seconds response time \[kex \d+\.\d{3}, auth \d+\.\d{3}\]$`, ckr.Message, "something went wrong")
+	}
+	testAuth()
+
+	testAuthFailure := func() {
+		ckr := run([]string{"-H", "127.0.0.1", "-p", port, "-u", "root", "-i", identity})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `^authentication failed with OpenSSH_7\.4: `, ckr.Message, "something went wrong")
+	}
+	testAuthFailure()
+
+	testRemoteVersion := func() {
+		ckr := run([]string{"-H", "127.0.0.1", "-p", port, "-P", "2.0", "-r", "OpenSSH_7.4"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+
+		ckr = run([]string{"-H", "127.0.0.1", "-p", port, "-r", "OpenSSH_8.0"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "software version OpenSSH_7.4 (expected OpenSSH_8.0)", ckr.Message, "something went wrong")
+	}
+	testRemoteVersion()
+
+	testNoKex := func() {
+		b := serveBanner(t)
+		defer b.Close()
+		_, port, _ := net.SplitHostPort(b.Addr().String())
+		ckr := run([]string{"-H", "127.0.0.1", "-p", port, "-t", "0.5"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `^key exchange failed with OpenSSH_7\.4: `, ckr.Message, "something went wrong")
+	}
+	testNoKex()
+}
+
+func TestParseVersion(t *testing.T) {
+	protocol, software, err := parseVersion("SSH-1.99-OpenSSH_3.9p1 comment")
+	assert.Nil(t, err, "err should be nil")
+	assert.Equal(t, "1.99", protocol, "something went wrong")
+	assert.Equal(t, "OpenSSH_3.9p1", software, "something went wrong")
+
+	_, _, err = parseVersion("SSH-2.0")
+	assert.Error(t, err, "should be an error")
+}
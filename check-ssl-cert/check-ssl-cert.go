@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type sslCertOpts struct {
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	File       string `short:"f" long:"file" description:"PEM file of the certificate followed by its intermediates"`
+	Host       string `short:"H" long:"host" description:"Host name or IP Address to fetch the certificate chain from"`
+	Port       int    `short:"p" long:"port" default:"443" description:"Port number"`
+	ServerName string `short:"n" long:"server-name" description:"Name to send via SNI and verify the certificate against (default: host)"`
+	CAFile     string `long:"ca-file" value-name:"FILE" description:"CA certificates file to verify the chain (default: system roots)"`
+	Warning    int64  `short:"w" long:"warning" default:"30" description:"Days before expiry to result in warning status"`
+	Critical   int64  `short:"c" long:"critical" default:"14" description:"Days before expiry to result in critical status"`
+	AllowWeak  bool   `long:"allow-weak-signature" description:"Do not treat MD5 or SHA-1 signatures as critical"`
+}
+
+func main() {
+	pluginutil.Main("SSL Cert", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&sslCertOpts{}, args)
+}
+
+func readChain(file string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return chain, nil
+}
+
+// fetchChain returns the certificate chain presented by the server. The
+// chain is verified afterwards so that every problem can be reported.
+func (opts *sslCertOpts) fetchChain() ([]*x509.Certificate, error) {
+	d := &net.Dialer{Timeout: opts.TimeoutDuration()}
+	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+	conn, err := tls.DialWithDialer(d, "tcp", address, &tls.Config{
+		ServerName:         opts.serverName(),
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+func (opts *sslCertOpts) serverName() string {
+	if opts.ServerName != "" {
+		return opts.ServerName
+	}
+	return opts.Host
+}
+
+func (opts *sslCertOpts) roots() (*x509.CertPool, error) {
+	if opts.CAFile == "" {
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(opts.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+	}
+	return pool, nil
+}
+
+var weakSignatures = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+func (opts *sslCertOpts) Run() *checkers.Checker {
+	if (opts.File == "") == (opts.Host == "") {
+		return checkers.Unknown("either --file or --host is required")
+	}
+	roots, err := opts.roots()
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	var chain []*x509.Certificate
+	if opts.File != "" {
+		chain, err = readChain(opts.File)
+		if err != nil {
+			return checkers.Unknown(err.Error())
+		}
+	} else {
+		chain, err = opts.fetchChain()
+		if err != nil {
+			return pluginutil.Failure(checkers.Critical(err.Error()))
+		}
+	}
+	leaf := chain[0]
+
+	chkSt := checkers.OK
+	var problems []string
+	critical := func(msg string) {
+		chkSt = checkers.CRITICAL
+		problems = append(problems, msg)
+	}
+
+	// the earliest expiry in the chain
+	expiring := leaf
+	for _, cert := range chain[1:] {
+		if cert.NotAfter.Before(expiring.NotAfter) {
+			expiring = cert
+		}
+	}
+	days := int64(expiring.NotAfter.Sub(time.Now()).Hours() / 24)
+	if days < opts.Critical {
+		chkSt = checkers.CRITICAL
+	} else if days < opts.Warning {
+		chkSt = checkers.WARNING
+	}
+	msg := fmt.Sprintf("%s expires in %d days", expiring.Subject.CommonName, days)
+
+	if !opts.AllowWeak {
+		for _, cert := range chain {
+			// signatures of self-signed roots are never verified
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+				continue
+			}
+			if weakSignatures[cert.SignatureAlgorithm] {
+				critical(fmt.Sprintf("%s is signed with weak algorithm %s", cert.Subject.CommonName, cert.SignatureAlgorithm))
+			}
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		critical("chain verification failed: " + err.Error())
+	}
+
+	if name := opts.serverName(); name != "" {
+		if err := leaf.VerifyHostname(name); err != nil {
+			critical(err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		msg += "; " + strings.Join(problems, "; ")
+	}
+	return checkers.NewChecker(chkSt, msg)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, days int, isCA bool, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Duration(days) * 24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.DNSNames = []string{cn}
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, der, key}
+}
+
+func writePEM(t *testing.T, path string, certs ...*testCert) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, c := range certs {
+		pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-ssl-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := newTestCert(t, "Test Root", 3650, true, nil)
+	inter := newTestCert(t, "Test Intermediate", 365, true, root)
+	leaf := newTestCert(t, "www.example.com", 60, false, inter)
+	soon := newTestCert(t, "soon.example.com", 20, false, inter)
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, root)
+
+	testOK := func() {
+		f := filepath.Join(dir, "ok.pem")
+		writePEM(t, f, leaf, inter)
+		ckr := run([]string{"-f", f, "--ca-file", caFile, "-n", "www.example.com"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `^www\.example\.com expires in (59|60) days$`, ckr.Message, "Unexpected response")
+	}
+	testOK()
+
+	testExpiring := func() {
+		f := filepath.Join(dir, "soon.pem")
+		writePEM(t, f, soon, inter)
+		ckr := run([]string{"-f", f, "--ca-file", caFile})
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		ckr = run([]string{"-f", f, "--ca-file", caFile, "-c", "21"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testExpiring()
+
+	testIncompleteChain := func() {
+		f := filepath.Join(dir, "incomplete.pem")
+		writePEM(t, f, leaf)
+		ckr := run([]string{"-f", f, "--ca-file", caFile})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `; chain verification failed: `, ckr.Message, "Unexpected response")
+	}
+	testIncompleteChain()
+
+	testHostnameMismatch := func() {
+		f := filepath.Join(dir, "ok.pem")
+		ckr := run([]string{"-f", f, "--ca-file", caFile, "-n", "mail.example.com"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `not mail\.example\.com`, ckr.Message, "Unexpected response")
+	}
+	testHostnameMismatch()
+
+	testNoCertificate := func() {
+		f := filepath.Join(dir, "empty.pem")
+		ioutil.WriteFile(f, []byte("not a certificate"), 0644)
+		ckr := run([]string{"-f", f})
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testNoCertificate()
+
+	testBothTargets := func() {
+		ckr := run([]string{"-f", filepath.Join(dir, "ok.pem"), "-H", "localhost"})
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testBothTargets()
+}
+
+func TestHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-ssl-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := newTestCert(t, "Test Root", 3650, true, nil)
+	inter := newTestCert(t, "Test Intermediate", 365, true, root)
+	leaf := newTestCert(t, "www.example.com", 60, false, inter)
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, root)
+
+	serve := func(chain ...*testCert) net.Listener {
+		cert := tls.Certificate{PrivateKey: chain[0].key}
+		for _, c := range chain {
+			cert.Certificate = append(cert.Certificate, c.der)
+		}
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				c.(*tls.Conn).Handshake()
+				c.Close()
+			}
+		}()
+		return l
+	}
+
+	testOK := func() {
+		l := serve(leaf, inter)
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		ckr := run([]string{"-H", host, "-p", port, "-n", "www.example.com", "--ca-file", caFile})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testOK()
+
+	testIncompleteChain := func() {
+		l := serve(leaf)
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		ckr := run([]string{"-H", host, "-p", port, "-n", "www.example.com", "--ca-file", caFile})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `; chain verification failed: `, ckr.Message, "Unexpected response")
+	}
+	testIncompleteChain()
+
+	testHostnameMismatch := func() {
+		l := serve(leaf, inter)
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		ckr := run([]string{"-H", host, "-p", port, "--ca-file", caFile})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testHostnameMismatch()
+
+	testRefused := func() {
+		l := serve(leaf)
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		l.Close()
+		ckr := run([]string{"-H", host, "-p", port})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testRefused()
+}
@@ -2,103 +2,222 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"net"
+	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
-	"io"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/proxy"
 )
 
 type tcpOpts struct {
-	Service  string `long:"service" description:"Service name. e.g. ftp, smtp, pop, imap and so on"`
+	pluginutil.Options
+	pluginutil.TimeoutOption
+	Service  string `long:"service" description:"Service name. e.g. ftp, smtp, pop, imap, redis, memcached, mysql and so on"`
 	Hostname string `short:"H" long:"hostname" description:"Host name or IP Address"`
+	IPv4     bool   `short:"4" long:"ipv4" description:"Use IPv4 connection"`
+	IPv6     bool   `short:"6" long:"ipv6" description:"Use IPv6 connection"`
 	exchange
-	Timeout  float64 `short:"t" long:"timeout" default:"10" description:"Seconds before connection times out"`
-	MaxBytes int     `short:"m" long:"maxbytes" description:"Close connection once more than this number of bytes are received"`
-	Delay    float64 `short:"d" long:"delay" description:"Seconds to wait between sending string and polling for response"`
-	Warning  float64 `short:"w" long:"warning" description:"Response time to result in warning status (seconds)"`
-	Critical float64 `short:"c" long:"critical" description:"Response time to result in critical status (seconds)"`
-	Escape   bool    `short:"E" long:"escape" description:"Can use \\n, \\r, \\t or \\ in send or quit string. Must come before send or quit option. By default, nothing added to send, \\r\\n added to end of quit"`
+	MaxBytes     int              `short:"m" long:"maxbytes" description:"Close connection once more than this number of bytes are received"`
+	Delay        float64          `short:"d" long:"delay" description:"Seconds to wait between sending string and polling for response"`
+	PreQuitDelay float64          `long:"pre-quit-delay" description:"Seconds to wait before sending quit string"`
+	Warning      pluginutil.Range `short:"w" long:"warning" value-name:"RANGE" description:"Response time to result in warning status (seconds). Nagios range, e.g. 3, 1:3 or @0:1"`
+	Critical     pluginutil.Range `short:"c" long:"critical" value-name:"RANGE" description:"Response time to result in critical status (seconds). Nagios range, e.g. 5, 1:5 or @0:1"`
+	Escape       bool             `short:"E" long:"escape" description:"Can use \\n, \\r, \\t or \\ in send or quit string. Must come before send or quit option. By default, nothing added to send, \\r\\n added to end of quit"`
+
+	Count       int  `long:"count" default:"1" description:"Number of sequential probes. Response time thresholds are evaluated against the average"`
+	Concurrency int  `long:"concurrency" value-name:"N" description:"Number of probes to run simultaneously. All of them must complete the exchange. Response time thresholds are evaluated against the average"`
+	UseMax      bool `long:"use-max" description:"Evaluate response time thresholds against the maximum of the probes instead of the average"`
+
+	Step  []string `long:"step" value-name:"send:LINE|expect:REGEXP" description:"Step of the dialog to run in order instead of --send and --expect-pattern. A line to send, terminated with CRLF, or a pattern to expect in the response (multiple --step options are allowed)"`
+	steps []step
+
+	ExpectClosed  bool   `long:"expect-closed" description:"Expect the port to be closed. OK if the connection is refused or times out, CRITICAL if it is accepted"`
+	MismatchState string `long:"mismatch-state" default:"crit" choice:"ok" choice:"warn" choice:"crit" description:"Status when the response doesn't match the expect patterns"`
+	TimeoutState  string `long:"timeout-state" default:"critical" choice:"ok" choice:"warning" choice:"critical" choice:"unknown" description:"Status when the connection times out"`
+
+	Timings         bool             `long:"timings" description:"Report the time taken by each phase, DNS resolution, TCP connect, TLS handshake and first byte of the response"`
+	ConnectWarning  pluginutil.Range `long:"connect-warning" value-name:"RANGE" description:"TCP connect time to result in warning status (seconds)"`
+	ConnectCritical pluginutil.Range `long:"connect-critical" value-name:"RANGE" description:"TCP connect time to result in critical status (seconds)"`
+
+	SNIName       string `long:"sni-name" description:"Server name to send via SNI and verify the certificate against (default: host name)"`
+	TLSCAFile     string `long:"tls-ca-file" value-name:"FILE" description:"CA certificates file to verify the server certificate"`
+	TLSCert       string `long:"tls-cert" value-name:"FILE" description:"Client certificate file for mutual TLS"`
+	TLSKey        string `long:"tls-key" value-name:"FILE" description:"Client private key file for mutual TLS"`
+	TLSMinVersion string `long:"tls-min-version" value-name:"VERSION" description:"Minimum TLS version. 1.0, 1.1, 1.2 or 1.3"`
+	tlsConfig     *tls.Config
+
+	CheckRevocation   bool    `long:"check-revocation" description:"Check the revocation status of the server certificate via OCSP (with --ssl)"`
+	RevocationTimeout float64 `long:"revocation-timeout" default:"10" description:"Seconds before OCSP query times out"`
+	RequireSCT        bool    `long:"require-sct" description:"Require signed certificate timestamps of Certificate Transparency (with --ssl)"`
+	CertWarning       int64   `long:"cert-warning" value-name:"DAYS" description:"Days before certificate expiry to result in warning status (with --ssl)"`
+	CertCritical      int64   `long:"cert-critical" value-name:"DAYS" description:"Days before certificate expiry to result in critical status (with --ssl)"`
+
+	StatusPrefix bool `long:"status-prefix" description:"Begin the message with one-letter status (O, W, C or U)"`
+	Perfdata     bool `long:"perfdata" description:"Append performance data of response time and received bytes to the output"`
+	DryRun       bool `long:"dry-run" description:"Print the exchange resolved from the service and the other options without connecting"`
+
+	Proxy  string `long:"proxy" value-name:"URL" description:"Proxy to connect through. e.g. socks5://host:port or http://host:port"`
+	dialer proxy.Dialer
+
+	ProxyProtocol string `long:"proxy-protocol" value-name:"VERSION" choice:"v1" choice:"v2" description:"Send the header of HAProxy PROXY protocol before the exchange. v1 or v2"`
+
+	SourceIP   string `long:"source-ip" description:"Local IP Address to connect from"`
+	SourcePort int    `long:"source-port" description:"Local port number to connect from"`
+
+	CompareHost   string  `long:"compare-host" description:"Host name or IP Address to probe in the same way and compare the response with"`
+	CompareFactor float64 `long:"compare-factor" description:"Response time ratio between hosts to result in warning status when comparing"`
+
+	Targets string `long:"targets" value-name:"HOST:PORT,..." description:"Comma separated targets to probe concurrently instead of host and port. The worst status is reported"`
+	targets []target
 }
 
 type exchange struct {
-	Port          int    `short:"p" long:"port" description:"Port number"`
-	Send          string `short:"s" long:"send" description:"String to send to the server"`
-	ExpectPattern string `short:"e" long:"expect-pattern" description:"Regexp pattern to expect in server response"`
-	Quit          string `short:"q" long:"quit" description:"String to send server to initiate a clean close of the connection"`
-	SSL           bool   `short:"S" long:"ssl" description:"Use SSL for the connection."`
-	UnixSock      string `short:"U" long:"unix-sock" description:"Unix Domain Socket"`
-	NoCheckCertificate bool `long:"no-check-certificate" description:"Do not check certificate"`
-	expectReg     *regexp.Regexp
+	Port               int      `short:"p" long:"port" description:"Port number"`
+	Send               string   `short:"s" long:"send" description:"String to send to the server"`
+	SendHex            string   `long:"send-hex" description:"Hex encoded bytes to send to the server instead of --send. e.g. 0100000001"`
+	ExpectPattern      []string `short:"e" long:"expect-pattern" description:"Regexp pattern to expect in server response (multiple -e options are allowed)"`
+	ExpectHex          []string `long:"expect-hex" description:"Hex encoded bytes to expect in server response (multiple options are allowed)"`
+	All                bool     `short:"A" long:"all" description:"All expect patterns must match (default: any)"`
+	ExpectClose        bool     `long:"expect-close" description:"Read the response until the server closes the connection before matching"`
+	NotExpectCode      string   `long:"not-expect-code" description:"Comma separated leading digits of response code to result in critical status. e.g. 4,5"`
+	Quit               string   `short:"q" long:"quit" description:"String to send server to initiate a clean close of the connection"`
+	SSL                bool     `short:"S" long:"ssl" description:"Use SSL for the connection."`
+	StartTLS           bool     `long:"starttls" description:"Upgrade the connection with STARTTLS before the exchange (with smtp, pop or imap service)"`
+	UDP                bool     `short:"u" long:"udp" description:"Use UDP instead of TCP"`
+	UnixSock           string   `short:"U" long:"unix-sock" value-name:"PATH" description:"Unix Domain Socket to connect to instead of host and port"`
+	NoCheckCertificate bool     `long:"no-check-certificate" description:"Do not check certificate"`
+	expectRegs         []*regexp.Regexp
+	expectBytes        []string
+	notExpectCodes     string
 }
 
 func main() {
-	opts, err := parseArgs(os.Args[1:])
-	if err != nil {
-		os.Exit(1)
+	pluginutil.Main("TCP", run)
+}
+
+func run(args []string) *checkers.Checker {
+	opts := &tcpOpts{}
+	ckr := pluginutil.Run(opts, args)
+	if ckr.Name == "" {
+		ckr.Name = opts.name()
 	}
-	ckr := opts.run()
-	ckr.Name = "TCP"
+	if opts.StatusPrefix {
+		prefixStatus(ckr)
+	}
+	return ckr
+}
+
+func (opts *tcpOpts) name() string {
 	if opts.Service != "" {
-		ckr.Name = opts.Service
+		return opts.Service
+	}
+	if opts.UDP {
+		return "UDP"
 	}
-	ckr.Exit()
+	return "TCP"
+}
+
+// prefixStatus prefixes the message rather than the name, which may be
+// given by --name or be a key of --format json
+func prefixStatus(ckr *checkers.Checker) {
+	ckr.Message = ckr.Status.String()[:1] + " " + ckr.Message
 }
 
 func parseArgs(args []string) (*tcpOpts, error) {
 	opts := &tcpOpts{}
-	_, err := flags.ParseArgs(opts, args)
+	err := pluginutil.Parse(opts, args)
 	return opts, err
 }
 
 var defaultExchangeMap = map[string]exchange{
 	"FTP": exchange{
 		Port:          21,
-		ExpectPattern: `^220`,
+		ExpectPattern: []string{`^220`},
 		Quit:          "QUIT",
 	},
 	"POP": exchange{
 		Port:          110,
-		ExpectPattern: `^\+OK`,
+		ExpectPattern: []string{`^\+OK`},
 		Quit:          "QUIT",
 	},
 	"SPOP": exchange{
 		Port:          995,
-		ExpectPattern: `^\+OK`,
+		ExpectPattern: []string{`^\+OK`},
 		Quit:          "QUIT",
 		SSL:           true,
 	},
 	"IMAP": exchange{
 		Port:          143,
-		ExpectPattern: `^\* OK`,
+		ExpectPattern: []string{`^\* OK`},
 		Quit:          "a1 LOGOUT",
 	},
 	"SIMAP": exchange{
 		Port:          993,
-		ExpectPattern: `^\* OK`,
+		ExpectPattern: []string{`^\* OK`},
 		Quit:          "a1 LOGOUT",
 		SSL:           true,
 	},
 	"SMTP": exchange{
 		Port:          25,
-		ExpectPattern: `^220`,
+		ExpectPattern: []string{`^220`},
 		Quit:          "QUIT",
 	},
 	"SSMTP": exchange{
 		Port:          465,
-		ExpectPattern: `^220`,
+		ExpectPattern: []string{`^220`},
 		Quit:          "QUIT",
 		SSL:           true,
 	},
+	"REDIS": exchange{
+		Port:          6379,
+		Send:          "PING\r\n",
+		ExpectPattern: []string{`^\+PONG`},
+		Quit:          "QUIT",
+	},
+	"MEMCACHED": exchange{
+		Port:          11211,
+		Send:          "version\r\n",
+		ExpectPattern: []string{`^VERSION `},
+		Quit:          "quit",
+	},
+	"MYSQL": exchange{
+		Port: 3306,
+		// initial handshake packet: 3 bytes payload length, sequence id 0
+		// and protocol version 10
+		ExpectPattern: []string{`(?s)^.{3}\x00\x0a`},
+	},
 }
 
 func (opts *tcpOpts) prepare() error {
 	opts.Service = strings.ToUpper(opts.Service)
+	unsetZero(&opts.Warning)
+	unsetZero(&opts.Critical)
+
+	if opts.Send != "" && opts.SendHex != "" {
+		return fmt.Errorf("--send and --send-hex can't be used together")
+	}
+
+	if len(opts.Step) > 0 && (opts.Send != "" || opts.SendHex != "" || len(opts.ExpectPattern) > 0 || len(opts.ExpectHex) > 0) {
+		return fmt.Errorf("--step can't be used with --send, --send-hex, --expect-pattern or --expect-hex")
+	}
 
 	if opts.Service != "" {
 		defaultEx, ok := defaultExchangeMap[opts.Service]
@@ -108,17 +227,113 @@ func (opts *tcpOpts) prepare() error {
 		opts.merge(defaultEx)
 	}
 
+	if len(opts.Step) > 0 {
+		// the steps take the place of the exchange of the service
+		opts.Send, opts.ExpectPattern = "", nil
+		steps, err := parseSteps(opts.Step, opts.Escape)
+		if err != nil {
+			return err
+		}
+		opts.steps = steps
+	}
 	if opts.Escape {
 		opts.Quit = escapedString(opts.Quit)
 		opts.Send = escapedString(opts.Send)
 	} else if opts.Quit != "" {
 		opts.Quit += "\r\n"
 	}
-	var err error
-	if opts.ExpectPattern != "" {
-		opts.expectReg, err = regexp.Compile(opts.ExpectPattern)
+	if opts.SendHex != "" {
+		send, err := hex.DecodeString(opts.SendHex)
+		if err != nil {
+			return fmt.Errorf("invalid send-hex: %s", err)
+		}
+		opts.Send = string(send)
 	}
-	return err
+	if opts.IPv4 && opts.IPv6 {
+		return fmt.Errorf("-4 and -6 can't be used together")
+	}
+	if opts.UDP && (opts.useTLS() || opts.UnixSock != "") {
+		return fmt.Errorf("--udp can't be used with --ssl, --starttls or --unix-sock")
+	}
+	if opts.StartTLS {
+		if opts.SSL {
+			return fmt.Errorf("--starttls can't be used with --ssl")
+		}
+		if _, ok := starttlsDialogs[opts.Service]; !ok {
+			return fmt.Errorf("--starttls requires smtp, pop or imap service")
+		}
+	}
+	if opts.Proxy != "" && (opts.UDP || opts.UnixSock != "") {
+		return fmt.Errorf("--proxy can't be used with --udp or --unix-sock")
+	}
+	if (opts.SourceIP != "" || opts.SourcePort != 0) && opts.UnixSock != "" {
+		return fmt.Errorf("--source-ip and --source-port can't be used with --unix-sock")
+	}
+	if err := opts.prepareDialer(); err != nil {
+		return err
+	}
+	if opts.useTLS() {
+		if err := opts.prepareTLS(); err != nil {
+			return err
+		}
+	}
+	if opts.CheckRevocation && !opts.useTLS() {
+		return fmt.Errorf("--check-revocation requires --ssl or --starttls")
+	}
+	if opts.RequireSCT && !opts.useTLS() {
+		return fmt.Errorf("--require-sct requires --ssl or --starttls")
+	}
+	if (opts.CertWarning > 0 || opts.CertCritical > 0) && !opts.useTLS() {
+		return fmt.Errorf("--cert-warning and --cert-critical require --ssl or --starttls")
+	}
+	if opts.ProxyProtocol != "" && (opts.UDP || opts.Proxy != "") {
+		// through a proxy, the addresses of the connection are those of the
+		// proxy rather than of the target
+		return fmt.Errorf("--proxy-protocol can't be used with --udp or --proxy")
+	}
+	if opts.Concurrency > 1 && (opts.Count > 1 || opts.CompareHost != "") {
+		return fmt.Errorf("--concurrency can't be used with --count or --compare-host")
+	}
+	if opts.ExpectClosed && (opts.UDP || opts.Proxy != "" || opts.CompareHost != "") {
+		return fmt.Errorf("--expect-closed can't be used with --udp, --proxy or --compare-host")
+	}
+	if opts.CompareHost != "" && opts.UnixSock != "" {
+		return fmt.Errorf("--compare-host can't be used with --unix-sock")
+	}
+	if opts.Targets != "" {
+		if opts.Hostname != "" || opts.UnixSock != "" || opts.CompareHost != "" || opts.Perfdata {
+			return fmt.Errorf("--targets can't be used with --hostname, --unix-sock, --compare-host or --perfdata")
+		}
+		targets, err := parseTargets(opts.Targets)
+		if err != nil {
+			return err
+		}
+		opts.targets = targets
+	}
+	if opts.NotExpectCode != "" {
+		for _, code := range strings.Split(opts.NotExpectCode, ",") {
+			code = strings.TrimSpace(code)
+			if len(code) != 1 || code[0] < '0' || code[0] > '9' {
+				return fmt.Errorf("invalid not-expect-code: %s", opts.NotExpectCode)
+			}
+			opts.notExpectCodes += code
+		}
+	}
+	for _, ptn := range opts.ExpectPattern {
+		reg, err := regexp.Compile(ptn)
+		if err != nil {
+			return err
+		}
+		opts.expectRegs = append(opts.expectRegs, reg)
+	}
+	for _, h := range opts.ExpectHex {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return fmt.Errorf("invalid expect-hex: %s", err)
+		}
+		opts.expectBytes = append(opts.expectBytes, string(b))
+	}
+	return nil
 }
 
 func (opts *tcpOpts) merge(ex exchange) {
@@ -128,27 +343,135 @@ func (opts *tcpOpts) merge(ex exchange) {
 	if opts.Send == "" {
 		opts.Send = ex.Send
 	}
-	if opts.ExpectPattern == "" {
+	if len(opts.ExpectPattern) == 0 && len(opts.ExpectHex) == 0 {
 		opts.ExpectPattern = ex.ExpectPattern
 	}
+	if opts.NotExpectCode == "" {
+		opts.NotExpectCode = ex.NotExpectCode
+	}
 	if opts.Quit == "" {
 		opts.Quit = ex.Quit
 	}
 	if !opts.SSL {
 		opts.SSL = ex.SSL
 	}
+	if !opts.UDP {
+		opts.UDP = ex.UDP
+	}
+}
+
+func (opts *tcpOpts) useTLS() bool {
+	return opts.SSL || opts.StartTLS
+}
+
+func (opts *tcpOpts) network() string {
+	network := "tcp"
+	if opts.UDP {
+		network = "udp"
+	}
+	if opts.IPv4 {
+		network += "4"
+	} else if opts.IPv6 {
+		network += "6"
+	}
+	return network
+}
+
+// address joins host and port. host may be a literal IPv6 address with or
+// without brackets.
+func (opts *tcpOpts) address(host string, port int) string {
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), strconv.Itoa(port))
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (opts *tcpOpts) prepareTLS() error {
+	conf := &tls.Config{
+		ServerName:         opts.SNIName,
+		InsecureSkipVerify: opts.NoCheckCertificate,
+	}
+	if opts.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", opts.TLSCAFile)
+		}
+	}
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		if opts.TLSCert == "" || opts.TLSKey == "" {
+			return fmt.Errorf("--tls-cert and --tls-key must be specified together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if opts.TLSMinVersion != "" {
+		v, ok := tlsVersions[opts.TLSMinVersion]
+		if !ok {
+			return fmt.Errorf("unknown TLS version: %s", opts.TLSMinVersion)
+		}
+		conf.MinVersion = v
+	}
+	opts.tlsConfig = conf
+	return nil
+}
+
+// tlsConfigFor returns the TLS config to connect to the address
+func (opts *tcpOpts) tlsConfigFor(address string) *tls.Config {
+	conf := opts.tlsConfig.Clone()
+	if conf.ServerName == "" {
+		conf.ServerName = address
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			conf.ServerName = host
+		}
+	}
+	return conf
 }
 
-func dial(network, address string, ssl bool, noCheckCertificate bool ) (net.Conn, error) {
-	if ssl {
-		return tls.Dial(network, address, &tls.Config{
-			InsecureSkipVerify: noCheckCertificate,
-		})
+func (opts *tcpOpts) prepareDialer() error {
+	nd := &net.Dialer{
+		Timeout: opts.TimeoutDuration(),
+	}
+	if opts.SourceIP != "" || opts.SourcePort != 0 {
+		var ip net.IP
+		if opts.SourceIP != "" {
+			ip = net.ParseIP(opts.SourceIP)
+			if ip == nil {
+				return fmt.Errorf("invalid source-ip: %s", opts.SourceIP)
+			}
+		}
+		if opts.UDP {
+			nd.LocalAddr = &net.UDPAddr{IP: ip, Port: opts.SourcePort}
+		} else {
+			nd.LocalAddr = &net.TCPAddr{IP: ip, Port: opts.SourcePort}
+		}
+	}
+	var d proxy.Dialer = nd
+	if opts.Proxy != "" {
+		u, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return err
+		}
+		d, err = newProxyDialer(u, d, opts.TimeoutDuration())
+		if err != nil {
+			return err
+		}
 	}
-	return net.Dial(network, address)
+	opts.dialer = d
+	return nil
 }
 
-func (opts *tcpOpts) run() *checkers.Checker {
+func (opts *tcpOpts) Run() *checkers.Checker {
 	err := opts.prepare()
 	if err != nil {
 		return checkers.Unknown(err.Error())
@@ -157,78 +480,464 @@ func (opts *tcpOpts) run() *checkers.Checker {
 	os.Setenv("LANG", "C")
 	os.Setenv("LC_ALL", "C")
 
-	address := fmt.Sprintf("%s:%d", opts.Hostname, opts.Port)
+	if opts.DryRun {
+		return opts.dryRun()
+	}
+	if len(opts.targets) > 0 {
+		return opts.runTargets()
+	}
+	return opts.check(opts.Hostname, opts.Port)
+}
+
+var timeoutStates = map[string]checkers.Status{
+	"ok":       checkers.OK,
+	"warning":  checkers.WARNING,
+	"critical": checkers.CRITICAL,
+	"unknown":  checkers.UNKNOWN,
+}
+
+var mismatchStates = map[string]checkers.Status{
+	"ok":   checkers.OK,
+	"warn": checkers.WARNING,
+	"crit": checkers.CRITICAL,
+}
+
+// checkClosed connects to host and port (or the unix socket) and expects
+// the connection not to be accepted
+func (opts *tcpOpts) checkClosed(host string, port int) *checkers.Checker {
+	network, address := opts.network(), opts.address(host, port)
+	if opts.UnixSock != "" {
+		network, address = "unix", opts.UnixSock
+	}
+	addrs, _, err := opts.resolve(network, address)
+	if err != nil {
+		return pluginutil.Failure(checkers.Unknown(err.Error()))
+	}
+	conn, err := opts.connect(network, addrs)
+	if err == nil {
+		conn.Close()
+		return checkers.Critical(fmt.Sprintf("Connection accepted on %s", address))
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return checkers.Ok(fmt.Sprintf("Connection timed out on %s", address))
+	}
+	if isRefused(err) {
+		return checkers.Ok(fmt.Sprintf("Connection refused on %s", address))
+	}
+	return pluginutil.Failure(checkers.Unknown(err.Error()))
+}
+
+// isRefused reports whether the connection is refused, or the unix socket
+// doesn't exist
+func isRefused(err error) bool {
+	if oe, ok := err.(*net.OpError); ok {
+		if se, ok := oe.Err.(*os.SyscallError); ok {
+			return se.Err == syscall.ECONNREFUSED || se.Err == syscall.ENOENT
+		}
+	}
+	return false
+}
+
+// check probes host and port (or the unix socket) and evaluates the result
+func (opts *tcpOpts) check(host string, port int) *checkers.Checker {
+	if opts.ExpectClosed {
+		return opts.checkClosed(host, port)
+	}
+	network, address := opts.network(), opts.address(host, port)
+	if opts.UnixSock != "" {
+		network, address = "unix", opts.UnixSock
+	}
+	if opts.Concurrency > 1 {
+		return opts.checkConcurrency(network, address, opts.location(host, port))
+	}
+	var pr *probeResult
+	var err error
+	var samples []time.Duration
+	var phaseSamples []phases
+	for i := 0; i < opts.Count || i == 0; i++ {
+		pr, err = opts.probe(network, address)
+		if err != nil {
+			return opts.errorChecker(err)
+		}
+		samples = append(samples, pr.elapsed)
+		phaseSamples = append(phaseSamples, pr.phases)
+	}
+	stats := newProbeStats(samples)
+	elapsed := opts.pick(stats)
+	ph := opts.pickPhases(phaseSamples)
+
+	var diffMsg string
+	if opts.CompareHost != "" {
+		diffMsg = opts.compare(pr)
+	}
+
+	chkSt := checkers.OK
+	if opts.Warning.Alert(elapsed.Seconds()) {
+		chkSt = checkers.WARNING
+	}
+	if opts.Critical.Alert(elapsed.Seconds()) {
+		chkSt = checkers.CRITICAL
+	}
+	if opts.ConnectWarning.Alert(ph.connect.Seconds()) && chkSt == checkers.OK {
+		chkSt = checkers.WARNING
+	}
+	if opts.ConnectCritical.Alert(ph.connect.Seconds()) {
+		chkSt = checkers.CRITICAL
+	}
+	msg := fmt.Sprintf("%.3f seconds response time on%s", float64(elapsed)/float64(time.Second), opts.location(host, port))
+	if pr.response != "" {
+		msg += fmt.Sprintf(" [%s]", strings.Trim(opts.printable(pr.response), "\r\n"))
+	}
+	if opts.CertWarning > 0 || opts.CertCritical > 0 {
+		days := int64(pr.certNotAfter.Sub(time.Now()).Hours() / 24)
+		if opts.CertCritical > 0 && days < opts.CertCritical {
+			chkSt = checkers.CRITICAL
+		} else if opts.CertWarning > 0 && days < opts.CertWarning && chkSt == checkers.OK {
+			chkSt = checkers.WARNING
+		}
+		msg += fmt.Sprintf("; certificate %s expires in %d days", pr.certSubject, days)
+	}
+	if opts.reportsTimings() {
+		msg += "; " + timingsMessage(ph)
+	}
+	if diffMsg != "" {
+		if chkSt == checkers.OK {
+			chkSt = checkers.WARNING
+		}
+		msg += "; " + diffMsg
+	}
+	if len(samples) > 1 {
+		msg += fmt.Sprintf("; %d probes min/avg/max/stddev = %.3f/%.3f/%.3f/%.3f seconds",
+			len(samples), stats.min.Seconds(), stats.avg.Seconds(), stats.max.Seconds(), stats.stddev.Seconds())
+	}
+	ckr := checkers.NewChecker(chkSt, msg)
+	if opts.Perfdata {
+		metrics := []pluginutil.Metric{
+			{
+				Label: "time", Value: elapsed.Seconds(), Decimals: 6, Unit: "s",
+				Warning:  opts.Warning.String(),
+				Critical: opts.Critical.String(),
+				Min:      "0",
+				Max:      pluginutil.FormatThreshold(opts.Timeout),
+			},
+			{Label: "size", Value: float64(pr.size), Unit: "B"},
+		}
+		if opts.reportsTimings() {
+			metrics = append(metrics, opts.timingsMetrics(ph)...)
+		}
+		pluginutil.AppendPerfdata(ckr, metrics...)
+	}
+	return ckr
+}
+
+// location describes where the check connects to for the message
+func (opts *tcpOpts) location(host string, port int) string {
+	if opts.UnixSock != "" {
+		return " socket " + opts.UnixSock
+	}
+	loc := ""
+	if host != "" {
+		loc += " " + host
+	}
+	if port > 0 {
+		loc += fmt.Sprintf(" port %d", port)
+	}
+	return loc
+}
+
+// checkError is an error reported with its own check status
+type checkError struct {
+	status checkers.Status
+	msg    string
+}
+
+func (e *checkError) Error() string {
+	return e.msg
+}
+
+// errorChecker reports the error of a probe as a failure to be retried. A
+// timeout is reported with the status of --timeout-state.
+func (opts *tcpOpts) errorChecker(err error) *checkers.Checker {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return pluginutil.Failure(checkers.NewChecker(timeoutStates[opts.TimeoutState], fmt.Sprintf("connection timed out after %gs", opts.Timeout)))
+	}
+	return pluginutil.Failure(errorChecker(err))
+}
+
+func errorChecker(err error) *checkers.Checker {
+	if e, ok := err.(*checkError); ok {
+		return checkers.NewChecker(e.status, e.msg)
+	}
+	return checkers.Critical(err.Error())
+}
+
+// matchExpect reports whether any (or all with --all) of the expect patterns
+// match the response
+func (opts *tcpOpts) matchExpect(res string) bool {
+	matches := make([]bool, 0, len(opts.expectRegs)+len(opts.expectBytes))
+	for _, reg := range opts.expectRegs {
+		matches = append(matches, reg.MatchString(res))
+	}
+	for _, b := range opts.expectBytes {
+		matches = append(matches, strings.Contains(res, b))
+	}
+	for _, matched := range matches {
+		if matched && !opts.All {
+			return true
+		}
+		if !matched && opts.All {
+			return false
+		}
+	}
+	return opts.All
+}
+
+// unsetZero unsets a threshold of 0, which has meant no threshold since
+// before the thresholds became ranges
+func unsetZero(r *pluginutil.Range) {
+	if v, err := strconv.ParseFloat(r.String(), 64); err == nil && v == 0 {
+		*r = pluginutil.Range{}
+	}
+}
+
+func (opts *tcpOpts) hasExpect() bool {
+	return len(opts.expectRegs) > 0 || len(opts.expectBytes) > 0
+}
+
+// printable hex encodes the response when the exchange or the response is
+// binary, e.g. the handshake of MySQL
+func (opts *tcpOpts) printable(res string) string {
+	if opts.SendHex != "" || len(opts.ExpectHex) > 0 || !isText(res) {
+		return hex.EncodeToString([]byte(res))
+	}
+	return res
+}
+
+// isText reports whether s consists of printable characters and line breaks
+func isText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && r != '\r' && r != '\n' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+type probeStats struct {
+	min, avg, max, stddev time.Duration
+}
+
+func newProbeStats(samples []time.Duration) probeStats {
+	st := probeStats{min: samples[0], max: samples[0]}
+	var sum time.Duration
+	for _, d := range samples {
+		if d < st.min {
+			st.min = d
+		}
+		if d > st.max {
+			st.max = d
+		}
+		sum += d
+	}
+	st.avg = sum / time.Duration(len(samples))
+	var variance float64
+	for _, d := range samples {
+		variance += math.Pow(float64(d-st.avg), 2)
+	}
+	st.stddev = time.Duration(math.Sqrt(variance / float64(len(samples))))
+	return st
+}
+
+type probeResult struct {
+	response string
+	elapsed  time.Duration
+	// the earliest expiry in the peer certificate chain
+	certNotAfter time.Time
+	certSubject  string
+	// bytes read from the server
+	size   int
+	phases phases
+}
+
+// probe runs the exchange against the address
+func (opts *tcpOpts) probe(network, address string) (*probeResult, error) {
 	start := time.Now()
 	if opts.Delay > 0 {
 		time.Sleep(time.Duration(opts.Delay) * time.Second)
 	}
-	var conn net.Conn
-	if opts.UnixSock != "" {
-		conn, err = dial("unix", opts.UnixSock, opts.SSL, opts.NoCheckCertificate)
-	} else {
-		conn, err = dial("tcp", address, opts.SSL, opts.NoCheckCertificate)
+	var ph phases
+	addrs, dnsElapsed, err := opts.resolve(network, address)
+	if err != nil {
+		return nil, err
 	}
+	ph.dns = dnsElapsed
+	connectStart := time.Now()
+	conn, err := opts.connect(network, addrs)
 	if err != nil {
-		return checkers.Critical(err.Error())
+		return nil, err
 	}
-	defer conn.Close()
+	ph.connect = time.Since(connectStart)
+	defer func() { conn.Close() }()
 
-	if opts.Send != "" {
-		err := write(conn, []byte(opts.Send), opts.Timeout)
+	if opts.ProxyProtocol != "" {
+		err := write(conn, proxyHeader(opts.ProxyProtocol, conn.LocalAddr(), conn.RemoteAddr()), opts.Timeout)
 		if err != nil {
-			return checkers.Critical(err.Error())
+			return nil, err
 		}
 	}
 
 	res := ""
-	if opts.expectReg != nil {
-		buf, err := slurp(conn, opts.MaxBytes, opts.Timeout)
+	tlsStart := time.Now()
+	if opts.SSL {
+		tlsConn := tls.Client(conn, opts.tlsConfigFor(address))
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		conn = tlsConn
+		ph.tls = time.Since(tlsStart)
+	}
+	if opts.StartTLS {
+		tlsConn, greeting, err := starttls(conn, opts.Service, opts.tlsConfigFor(address), opts.Timeout)
 		if err != nil {
-			return checkers.Critical(err.Error())
+			return nil, err
+		}
+		conn = tlsConn
+		ph.tls = time.Since(tlsStart)
+		// the greeting is checked unless something is sent after STARTTLS
+		res = greeting
+	}
+	ready := time.Now()
+	fconn := &firstByteConn{Conn: conn}
+
+	if len(opts.steps) > 0 {
+		res, err = opts.runSteps(fconn)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if opts.Send != "" {
+			err := write(fconn, []byte(opts.Send), opts.Timeout)
+			if err != nil {
+				return nil, err
+			}
 		}
-		res = string(buf)
-		if !opts.expectReg.MatchString(res) {
-			return checkers.Critical("Unexpected response from host/socket: " + res)
+
+		// a UDP check always waits for a datagram, as nothing tells that
+		// the datagram sent has been received
+		if opts.hasExpect() || opts.notExpectCodes != "" || opts.UDP {
+			if !opts.StartTLS || opts.Send != "" {
+				buf, err := slurp(fconn, opts.MaxBytes, opts.Timeout, opts.ExpectClose)
+				if err != nil {
+					return nil, err
+				}
+				res = string(buf)
+			}
+			if opts.hasExpect() && !opts.matchExpect(res) {
+				return nil, &checkError{mismatchStates[opts.MismatchState], "Unexpected response from host/socket: " + opts.printable(res)}
+			}
+			if res != "" && strings.IndexByte(opts.notExpectCodes, res[0]) >= 0 {
+				return nil, &checkError{checkers.CRITICAL, "Error response from host/socket: " + res}
+			}
 		}
 	}
 
+	// the clock stops before the pre-quit delay, which is not a part of
+	// the response time
+	elapsed := time.Now().Sub(start)
 	if opts.Quit != "" {
-		err := write(conn, []byte(opts.Quit), opts.Timeout)
+		if opts.PreQuitDelay > 0 {
+			time.Sleep(time.Duration(opts.PreQuitDelay * float64(time.Second)))
+		}
+		err := write(fconn, []byte(opts.Quit), opts.Timeout)
 		if err != nil {
-			return checkers.Critical(err.Error())
+			return nil, err
 		}
 	}
-	elapsed := time.Now().Sub(start)
+	if !fconn.at.IsZero() {
+		ph.firstByte = fconn.at.Sub(ready)
+	}
 
-	chkSt := checkers.OK
-	if opts.Warning > 0 && elapsed > time.Duration(opts.Warning)*time.Second {
-		chkSt = checkers.WARNING
+	if opts.CheckRevocation {
+		ocspRes, err := queryOCSP(conn.(*tls.Conn).ConnectionState(), opts.RevocationTimeout)
+		if err != nil {
+			return nil, &checkError{checkers.UNKNOWN, "failed to check revocation status: " + err.Error()}
+		}
+		switch ocspRes.Status {
+		case ocsp.Revoked:
+			return nil, fmt.Errorf("certificate has been revoked at %s", ocspRes.RevokedAt.Format(time.RFC3339))
+		case ocsp.Unknown:
+			return nil, &checkError{checkers.UNKNOWN, "OCSP responder doesn't know about the certificate"}
+		}
 	}
-	if opts.Critical > 0 && elapsed > time.Duration(opts.Critical)*time.Second {
-		chkSt = checkers.CRITICAL
+	if opts.RequireSCT && !hasSCT(conn.(*tls.Conn).ConnectionState()) {
+		return nil, errors.New("no signed certificate timestamps")
 	}
-	msg := fmt.Sprintf("%.3f seconds response time on", float64(elapsed)/float64(time.Second))
-	if opts.Hostname != "" {
-		msg += " " + opts.Hostname
+
+	pr := &probeResult{response: res, elapsed: elapsed, size: len(res), phases: ph}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		for _, cert := range tlsConn.ConnectionState().PeerCertificates {
+			if pr.certNotAfter.IsZero() || cert.NotAfter.Before(pr.certNotAfter) {
+				pr.certNotAfter = cert.NotAfter
+				pr.certSubject = cert.Subject.CommonName
+			}
+		}
+	}
+	return pr, nil
+}
+
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// hasSCT reports whether signed certificate timestamps are delivered via
+// the TLS extension or embedded in the peer certificate
+func hasSCT(state tls.ConnectionState) bool {
+	if len(state.SignedCertificateTimestamps) > 0 {
+		return true
 	}
-	if opts.Port > 0 {
-		msg += fmt.Sprintf(" port %d", opts.Port)
+	if len(state.PeerCertificates) == 0 {
+		return false
+	}
+	for _, ext := range state.PeerCertificates[0].Extensions {
+		if ext.Id.Equal(oidSCTList) {
+			return true
+		}
 	}
-	if res != "" {
-		msg += fmt.Sprintf(" [%s]", strings.Trim(res, "\r\n"))
+	return false
+}
+
+// compare probes the compare host and describes how it differs from the
+// primary result. It returns an empty string if both are alike.
+func (opts *tcpOpts) compare(pr *probeResult) string {
+	cmp, err := opts.probe(opts.network(), opts.address(opts.CompareHost, opts.Port))
+	if err != nil {
+		return fmt.Sprintf("%s failed: %s", opts.CompareHost, err)
+	}
+	if strings.Trim(cmp.response, "\r\n") != strings.Trim(pr.response, "\r\n") {
+		return fmt.Sprintf("response differs on %s [%s]", opts.CompareHost, strings.Trim(opts.printable(cmp.response), "\r\n"))
+	}
+	if opts.CompareFactor > 0 {
+		fast, slow := pr.elapsed, cmp.elapsed
+		if fast > slow {
+			fast, slow = slow, fast
+		}
+		if float64(slow) > float64(fast)*opts.CompareFactor {
+			return fmt.Sprintf("%.3f seconds response time on %s", cmp.elapsed.Seconds(), opts.CompareHost)
+		}
 	}
-	return checkers.NewChecker(chkSt, msg)
+	return ""
 }
 
 func write(conn net.Conn, content []byte, timeout float64) error {
 	if timeout > 0 {
-		conn.SetWriteDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		conn.SetWriteDeadline(time.Now().Add(pluginutil.Seconds(timeout)))
 	}
 	_, err := conn.Write(content)
 	return err
 }
 
-func slurp(conn net.Conn, maxbytes int, timeout float64) ([]byte, error) {
+func slurp(conn net.Conn, maxbytes int, timeout float64, untilEOF bool) ([]byte, error) {
 	buf := []byte{}
 	readLimit := 32 * 1024
 	if maxbytes > 0 {
@@ -236,7 +945,7 @@ func slurp(conn net.Conn, maxbytes int, timeout float64) ([]byte, error) {
 	}
 	readBytes := 0
 	if timeout > 0 {
-		conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		conn.SetReadDeadline(time.Now().Add(pluginutil.Seconds(timeout)))
 	}
 	for {
 		tmpBuf := make([]byte, readLimit)
@@ -244,7 +953,7 @@ func slurp(conn net.Conn, maxbytes int, timeout float64) ([]byte, error) {
 		if i > 0 {
 			buf = append(buf, tmpBuf[:i]...)
 			readBytes += i
-			if i < readLimit || (maxbytes > 0 && maxbytes <= readBytes) {
+			if (!untilEOF && i < readLimit) || (maxbytes > 0 && maxbytes <= readBytes) {
 				break
 			}
 		}
@@ -1,19 +1,40 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"github.com/mackerelio/go-check-plugins/pluginutil/mockserver"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
 )
 
 func TestEscapedString(t *testing.T) {
@@ -24,17 +45,39 @@ func TestEscapedString(t *testing.T) {
 	assert.Equal(t, "", escapedString(``), "something went wrong")
 }
 
+func TestPrefixStatus(t *testing.T) {
+	for st, prefix := range map[checkers.Status]string{
+		checkers.OK:       "O",
+		checkers.WARNING:  "W",
+		checkers.CRITICAL: "C",
+		checkers.UNKNOWN:  "U",
+	} {
+		ckr := checkers.NewChecker(st, "message")
+		ckr.Name = "TCP"
+		prefixStatus(ckr)
+		assert.Equal(t, prefix+" message", ckr.Message, "something went wrong")
+		assert.Equal(t, "TCP", ckr.Name, "the name should be left alone")
+	}
+
+	l := serveBanner(t, "127.0.0.1:0", "+OK ready")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	ckr := run([]string{"-H", host, "-p", port, "-e", "OK", "--name", "pop-main", "--status-prefix"})
+	assert.Equal(t, "pop-main", ckr.Name, "the name should be left alone")
+	assert.Regexp(t, `^O \d+\.\d{3} seconds response time`, ckr.Message, "something went wrong")
+}
+
 func TestTLS(t *testing.T) {
 	opts, err := parseArgs([]string{"-S", "-H", "www.verisign.com", "-p", "443"})
 	assert.Equal(t, nil, err, "no errors")
-	ckr := opts.run()
+	ckr := opts.Run()
 	assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
 }
 
 func TestFTP(t *testing.T) {
 	opts, err := parseArgs([]string{"--service=ftp", "-H", "ftp.iij.ad.jp"})
 	assert.Equal(t, nil, err, "no errors")
-	ckr := opts.run()
+	ckr := opts.Run()
 	assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
 }
 
@@ -54,7 +97,7 @@ func TestHTTP(t *testing.T) {
 	testOk := func() {
 		opts, err := parseArgs([]string{"-H", host, "-p", port, "--send", `GET / HTTP/1.1\r\n\r\n`, "-E", "-e", "OKOK"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
@@ -64,7 +107,7 @@ func TestHTTP(t *testing.T) {
 		opts, err := parseArgs(
 			[]string{"-H", host, "-p", port, "--send", `GET / HTTP/1.1\r\n\r\n`, "-E", "-e", "OKOKOK"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
 		assert.Regexp(t, `Unexpected response from`, ckr.Message, "Unexpected response")
 	}
@@ -74,7 +117,7 @@ func TestHTTP(t *testing.T) {
 		opts, err := parseArgs(
 			[]string{"-H", host, "-p", port, "--send", `GET / HTTP/1.1\r\n\r\n`, "-E", "-e", "OKOK", "-w", "0.1"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.WARNING, ckr.Status, "should be Warning")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
@@ -84,7 +127,7 @@ func TestHTTP(t *testing.T) {
 		opts, err := parseArgs(
 			[]string{"-H", host, "-p", port, "--send", "GET / HTTP/1.1\r\n\r\n", "-e", "OKOK", "-c", "0.1"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be Critical")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
@@ -122,6 +165,8 @@ func TestUnixDomainSocket(t *testing.T) {
 					return
 				}
 
+				// as slow as TestHTTP for the thresholds of 0.1 seconds
+				time.Sleep(time.Second / 5)
 				c.Write([]byte("OKOK"))
 			}(ls)
 		}
@@ -130,16 +175,25 @@ func TestUnixDomainSocket(t *testing.T) {
 	testOk := func() {
 		opts, err := parseArgs([]string{"-U", sock, "--send", `PING`, "-E", "-e", "OKOK"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
 	testOk()
 
+	testWithPort := func() {
+		opts, err := parseArgs([]string{"-U", sock, "-p", "110", "--send", `PING`, "-E", "-e", "OKOK"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `seconds response time on socket `+sock+` \[OKOK\]$`, ckr.Message, "Unexpected response")
+	}
+	testWithPort()
+
 	testUnexpected := func() {
 		opts, err := parseArgs([]string{"-U", sock, "--send", `PING`, "-E", "-e", "OKOKOK"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
 		assert.Regexp(t, `Unexpected response from`, ckr.Message, "Unexpected response")
 	}
@@ -148,7 +202,7 @@ func TestUnixDomainSocket(t *testing.T) {
 	testOverWarn := func() {
 		opts, err := parseArgs([]string{"-U", sock, "--send", `PING`, "-E", "-e", "OKOK", "-w", "0.1"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.WARNING, ckr.Status, "should be Warning")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
@@ -157,7 +211,7 @@ func TestUnixDomainSocket(t *testing.T) {
 	testOverCrit := func() {
 		opts, err := parseArgs([]string{"-U", sock, "--send", `PING`, "-E", "-e", "OKOK", "-c", "0.1"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be Critical")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
@@ -189,7 +243,7 @@ func TestHTTPIPv6(t *testing.T) {
 	testOk := func() {
 		opts, err := parseArgs([]string{"-H", host, "-p", port, "--send", `GET / HTTP/1.1\r\n\r\n`, "-E", "-e", "OKOK"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
@@ -199,7 +253,7 @@ func TestHTTPIPv6(t *testing.T) {
 		opts, err := parseArgs(
 			[]string{"-H", host, "-p", port, "--send", `GET / HTTP/1.1\r\n\r\n`, "-E", "-e", "OKOKOK"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
 		assert.Regexp(t, `Unexpected response from`, ckr.Message, "Unexpected response")
 	}
@@ -209,7 +263,7 @@ func TestHTTPIPv6(t *testing.T) {
 		opts, err := parseArgs(
 			[]string{"-H", host, "-p", port, "--send", `GET / HTTP/1.1\r\n\r\n`, "-E", "-e", "OKOK", "-w", "0.1"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.WARNING, ckr.Status, "should be Warning")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
@@ -219,9 +273,1839 @@ func TestHTTPIPv6(t *testing.T) {
 		opts, err := parseArgs(
 			[]string{"-H", host, "-p", port, "--send", "GET / HTTP/1.1\r\n\r\n", "-e", "OKOK", "-c", "0.1"})
 		assert.Equal(t, nil, err, "no errors")
-		ckr := opts.run()
+		ckr := opts.Run()
 		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be Critical")
 		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
 	}
 	testOverCrit()
 }
+
+func TestPreQuitDelay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// the server only accepts QUIT once it has finished processing the
+	// previous command, which takes 100ms
+	accepted := make(chan bool, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 1024)
+				if _, err := c.Read(buf); err != nil {
+					return
+				}
+				c.Write([]byte("+OK"))
+				processed := time.Now()
+				if _, err := c.Read(buf); err != nil {
+					return
+				}
+				accepted <- time.Since(processed) >= 100*time.Millisecond
+			}(c)
+		}
+	}()
+
+	testImmediate := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-s", "PING", "-e", "OK", "-q", "QUIT"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.False(t, <-accepted, "quit should be rejected by the server")
+	}
+	testImmediate()
+
+	testDelayed := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-s", "PING", "-e", "OK", "-q", "QUIT", "--pre-quit-delay", "0.2", "-w", "0.1"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "the delay should not be counted in the response time")
+		assert.True(t, <-accepted, "quit should be accepted by the server")
+	}
+	testDelayed()
+}
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert issues a certificate from template signed by parent. A self
+// signed certificate is issued when parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "check-tcp test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+// serveTLS starts a TLS server presenting the chain and replying "OKOK" to
+// every connection
+func serveTLS(t *testing.T, chain ...*testCert) net.Listener {
+	tlsCert := tls.Certificate{PrivateKey: chain[0].key, Leaf: chain[0].cert}
+	for _, c := range chain {
+		tlsCert.Certificate = append(tlsCert.Certificate, c.cert.Raw)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tlsCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte("OKOK"))
+				ioutil.ReadAll(c)
+			}(c)
+		}
+	}()
+	return l
+}
+
+func TestCheckRevocation(t *testing.T) {
+	ca := newTestCA(t)
+
+	status := ocsp.Good
+	// serial overrides the serial number of the response when it is set
+	var serial *big.Int
+	ocspServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		ocspReq, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resSerial := ocspReq.SerialNumber
+		if serial != nil {
+			resSerial = serial
+		}
+		res, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: resSerial,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, ca.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(res)
+	}))
+	defer ocspServer.Close()
+
+	leaf := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{ocspServer.URL},
+	}, ca)
+	l := serveTLS(t, leaf, ca)
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testGood := func() {
+		status = ocsp.Good
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "-e", "OKOK", "--check-revocation"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
+	}
+	testGood()
+
+	testRevoked := func() {
+		status = ocsp.Revoked
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "-e", "OKOK", "--check-revocation"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `certificate has been revoked`, ckr.Message, "Unexpected response")
+	}
+	testRevoked()
+
+	testOtherSerial := func() {
+		status = ocsp.Good
+		serial = big.NewInt(3)
+		defer func() { serial = nil }()
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "-e", "OKOK", "--check-revocation"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Regexp(t, `failed to check revocation status`, ckr.Message, "Unexpected response")
+	}
+	testOtherSerial()
+
+	testWithoutSSL := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--check-revocation"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testWithoutSSL()
+}
+
+// serveBanner starts a server on address replying banner to every connection
+func serveBanner(t *testing.T, address string, banner string) net.Listener {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte(banner))
+				ioutil.ReadAll(c)
+			}(c)
+		}
+	}()
+	return l
+}
+
+func TestCompareHost(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "+OK ready")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testMatching := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--compare-host", host})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
+	}
+	testMatching()
+
+	l6 := serveBanner(t, net.JoinHostPort("::1", port), "+OK ready (maintenance)")
+	defer l6.Close()
+
+	testDiverging := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--compare-host", "[::1]"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		assert.Regexp(t, `response differs on \[::1\] \[\+OK ready \(maintenance\)\]`, ckr.Message, "Unexpected response")
+	}
+	testDiverging()
+}
+
+func TestNotExpectCode(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "220 mail.example.com ESMTP ready\r\n")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testOk := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--not-expect-code", "4,5"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[220 mail.example.com ESMTP ready\]`, ckr.Message, "Unexpected response")
+	}
+	testOk()
+
+	l5 := serveBanner(t, "127.0.0.1:0", "554 no SMTP service here\r\n")
+	defer l5.Close()
+	host, port, _ = net.SplitHostPort(l5.Addr().String())
+
+	testError := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--not-expect-code", "4,5"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `Error response from host/socket: 554`, ckr.Message, "Unexpected response")
+	}
+	testError()
+
+	testInvalid := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--not-expect-code", "45"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testInvalid()
+}
+
+func TestRequireSCT(t *testing.T) {
+	ca := newTestCA(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	l := serveTLS(t, newTestCert(t, template, ca), ca)
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testAbsent := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "--require-sct"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "no signed certificate timestamps", ckr.Message, "Unexpected response")
+	}
+	testAbsent()
+
+	sctList, _ := asn1.Marshal([]byte{0x00, 0x02, 0x00, 0x00})
+	template.ExtraExtensions = []pkix.Extension{{
+		Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2},
+		Value: sctList,
+	}}
+	lSCT := serveTLS(t, newTestCert(t, template, ca), ca)
+	defer lSCT.Close()
+	host, port, _ = net.SplitHostPort(lSCT.Addr().String())
+
+	testEmbedded := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "--require-sct"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testEmbedded()
+}
+
+func TestCertExpiration(t *testing.T) {
+	ca := newTestCA(t)
+	leaf := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10*24*time.Hour + time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	l := serveTLS(t, leaf, ca)
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testOk := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "--cert-warning", "7", "--cert-critical", "3"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `certificate localhost expires in 10 days`, ckr.Message, "Unexpected response")
+	}
+	testOk()
+
+	testWarning := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "--cert-warning", "30", "--cert-critical", "7"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+	}
+	testWarning()
+
+	testCritical := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-S", "--no-check-certificate", "--cert-warning", "30", "--cert-critical", "14"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testCritical()
+}
+
+func TestUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	host, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "PING" {
+				pc.WriteTo([]byte("PONG"), addr)
+			}
+		}
+	}()
+
+	testOk := func() {
+		opts, err := parseArgs([]string{"-u", "-H", host, "-p", port, "-s", "PING", "-e", "PONG"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
+	}
+	testOk()
+
+	testUnexpected := func() {
+		opts, err := parseArgs([]string{"-u", "-H", host, "-p", port, "-s", "PING", "-e", "PANG"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `Unexpected response from`, ckr.Message, "Unexpected response")
+	}
+	testUnexpected()
+
+	testNoResponse := func() {
+		opts, err := parseArgs([]string{"-u", "-H", host, "-p", port, "-s", "HELLO", "-e", "PONG", "-t", "1"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testNoResponse()
+
+	testNoExpect := func() {
+		opts, err := parseArgs([]string{"-u", "-H", host, "-p", port, "-s", "PING"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[PONG\]$`, ckr.Message, "Unexpected response")
+	}
+	testNoExpect()
+
+	testNoExpectNoResponse := func() {
+		opts, err := parseArgs([]string{"-u", "-H", host, "-p", port, "-s", "HELLO", "-t", "0.5"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "connection timed out after 0.5s", ckr.Message, "Unexpected response")
+	}
+	testNoExpectNoResponse()
+
+	testClosed := func() {
+		closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, closedPort, _ := net.SplitHostPort(closed.LocalAddr().String())
+		closed.Close()
+		opts, err := parseArgs([]string{"-u", "-H", "127.0.0.1", "-p", closedPort, "-s", "PING", "-t", "1"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `connection refused`, ckr.Message, "Unexpected response")
+	}
+	testClosed()
+}
+
+func TestExpectClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte("220 hello\r\n"))
+				time.Sleep(50 * time.Millisecond)
+				c.Write([]byte("250 TOKEN ready\r\n"))
+			}(c)
+		}
+	}()
+
+	testFirstRead := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "TOKEN"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `Unexpected response from`, ckr.Message, "Unexpected response")
+	}
+	testFirstRead()
+
+	testUntilClose := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "TOKEN", "--expect-close"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `TOKEN ready`, ckr.Message, "Unexpected response")
+	}
+	testUntilClose()
+}
+
+func TestMultipleExpect(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "220-mail.example.com ESMTP\r\n220 ready\r\n")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testAny := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "ESMTP", "-e", "LMTP"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testAny()
+
+	testAnyUnexpected := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "SMTPUTF8", "-e", "LMTP"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `Unexpected response from`, ckr.Message, "Unexpected response")
+	}
+	testAnyUnexpected()
+
+	testAll := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "ESMTP", "-e", "220 ready", "--all"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testAll()
+
+	testAllUnexpected := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "ESMTP", "-e", "LMTP", "-A"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `Unexpected response from`, ckr.Message, "Unexpected response")
+	}
+	testAllUnexpected()
+}
+
+// serveSMTP starts a minimal SMTP server which supports STARTTLS when cert
+// is given
+func serveSMTP(t *testing.T, cert *testCert) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte("220 mail.example.com ESMTP\r\n"))
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch strings.TrimSpace(line) {
+					case "EHLO localhost":
+						c.Write([]byte("250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n"))
+					case "STARTTLS":
+						if cert == nil {
+							c.Write([]byte("454 TLS not available\r\n"))
+							continue
+						}
+						c.Write([]byte("220 Ready to start TLS\r\n"))
+						tlsCert := tls.Certificate{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}
+						c = tls.Server(c, &tls.Config{Certificates: []tls.Certificate{tlsCert}})
+						r = bufio.NewReader(c)
+					case "NOOP":
+						c.Write([]byte("250 OK\r\n"))
+					case "QUIT":
+						c.Write([]byte("221 Bye\r\n"))
+						return
+					default:
+						c.Write([]byte("502 Command not implemented\r\n"))
+					}
+				}
+			}(c)
+		}
+	}()
+	return l
+}
+
+func TestStartTLS(t *testing.T) {
+	ca := newTestCA(t)
+	leaf := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	l := serveSMTP(t, leaf)
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testGreeting := func() {
+		opts, err := parseArgs([]string{"--service=smtp", "-H", host, "-p", port, "--starttls", "--no-check-certificate"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[220 mail.example.com ESMTP\]`, ckr.Message, "Unexpected response")
+	}
+	testGreeting()
+
+	testSend := func() {
+		opts, err := parseArgs([]string{"--service=smtp", "-H", host, "-p", port, "--starttls", "--no-check-certificate", "-E", "-s", `NOOP\r\n`, "-e", "^250"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[250 OK\]`, ckr.Message, "Unexpected response")
+	}
+	testSend()
+
+	testUntrusted := func() {
+		opts, err := parseArgs([]string{"--service=smtp", "-H", host, "-p", port, "--starttls"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testUntrusted()
+
+	lPlain := serveSMTP(t, nil)
+	defer lPlain.Close()
+	host, port, _ = net.SplitHostPort(lPlain.Addr().String())
+
+	testUnavailable := func() {
+		opts, err := parseArgs([]string{"--service=smtp", "-H", host, "-p", port, "--starttls"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "STARTTLS failed: 454 TLS not available", ckr.Message, "Unexpected response")
+	}
+	testUnavailable()
+
+	testUnsupportedService := func() {
+		opts, err := parseArgs([]string{"--service=ftp", "-H", host, "-p", port, "--starttls"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testUnsupportedService()
+}
+
+// writePEM writes the certificate and its private key into dir and returns
+// their paths
+func writePEM(t *testing.T, dir, name string, c *testCert) (string, string) {
+	certFile := filepath.Join(dir, name+".pem")
+	err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSClientOptions(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	caFile, _ := writePEM(t, dir, "ca", ca)
+	serverCert := func(name string) tls.Certificate {
+		c := newTestCert(t, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca)
+		return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+	}
+	defaultCert, mailCert := serverCert("default.example.com"), serverCert("mail.example.com")
+	clientCertFile, clientKeyFile := writePEM(t, dir, "client", newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "monitoring"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca))
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "mail.example.com" {
+				return &mailCert, nil
+			}
+			return &defaultCert, nil
+		},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MaxVersion: tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte("OKOK"))
+				ioutil.ReadAll(c)
+			}(c)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	args := []string{"-H", host, "-p", port, "-S", "-e", "OKOK", "--tls-ca-file", caFile}
+
+	testOk := func() {
+		opts, err := parseArgs(append(args, "--sni-name", "mail.example.com", "--tls-cert", clientCertFile, "--tls-key", clientKeyFile))
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `seconds response time on`, ckr.Message, "Unexpected response")
+	}
+	testOk()
+
+	testWithoutSNI := func() {
+		opts, err := parseArgs(append(args, "--tls-cert", clientCertFile, "--tls-key", clientKeyFile))
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testWithoutSNI()
+
+	testWithoutClientCert := func() {
+		opts, err := parseArgs(append(args, "--sni-name", "mail.example.com"))
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testWithoutClientCert()
+
+	testMinVersion := func() {
+		opts, err := parseArgs(append(args, "--sni-name", "mail.example.com", "--tls-cert", clientCertFile, "--tls-key", clientKeyFile, "--tls-min-version", "1.3"))
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `protocol version`, ckr.Message, "Unexpected response")
+	}
+	testMinVersion()
+
+	testInvalidOptions := func() {
+		opts, err := parseArgs(append(args, "--tls-min-version", "2.0"))
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+
+		opts, err = parseArgs(append(args, "--tls-cert", clientCertFile))
+		assert.Equal(t, nil, err, "no errors")
+		ckr = opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testInvalidOptions()
+}
+
+func TestAddressFamily(t *testing.T) {
+	l := serveBanner(t, "[::1]:0", "OKOK")
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testLiteral := func() {
+		opts, err := parseArgs([]string{"-H", "::1", "-p", port, "-e", "OKOK"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `seconds response time on ::1 port `+port, ckr.Message, "Unexpected response")
+	}
+	testLiteral()
+
+	testIPv6 := func() {
+		opts, err := parseArgs([]string{"-6", "-H", "::1", "-p", port, "-e", "OKOK"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testIPv6()
+
+	testIPv4 := func() {
+		opts, err := parseArgs([]string{"-4", "-H", "::1", "-p", port, "-e", "OKOK"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testIPv4()
+
+	testBoth := func() {
+		opts, err := parseArgs([]string{"-4", "-6", "-H", "::1", "-p", port})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testBoth()
+}
+
+func TestRetries(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// the server drops connections until it has been asked to recover
+	var dropping int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if atomic.AddInt32(&dropping, -1) >= 0 {
+				c.Close()
+				continue
+			}
+			c.Write([]byte("OKOK"))
+			c.Close()
+		}
+	}()
+
+	testRecovered := func() {
+		atomic.StoreInt32(&dropping, 2)
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OKOK", "--retries", "2", "--retry-interval", "0.01"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := pluginutil.RunCheck(opts)
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testRecovered()
+
+	testFailed := func() {
+		atomic.StoreInt32(&dropping, 2)
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OKOK", "--retries", "1", "--retry-interval", "0.01"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := pluginutil.RunCheck(opts)
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `\(2 attempts\)$`, ckr.Message, "Unexpected response")
+	}
+	testFailed()
+
+	testPerfdata := func() {
+		atomic.StoreInt32(&dropping, 2)
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OKOK", "--retries", "1", "--retry-interval", "0.01", "--perfdata"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := pluginutil.RunCheck(opts)
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.NotContains(t, ckr.Message, "|", "something went wrong")
+		assert.Regexp(t, `\(2 attempts\)$`, ckr.Message, "Unexpected response")
+	}
+	testPerfdata()
+
+	testThreshold := func() {
+		atomic.StoreInt32(&dropping, 0)
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OKOK", "--retries", "2", "--retry-interval", "0.01", "-w", "0.000001"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := pluginutil.RunCheck(opts)
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		assert.NotContains(t, ckr.Message, "attempts", "threshold results should not be retried")
+	}
+	testThreshold()
+}
+
+func TestPerfdata(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "+OK ready\r\n")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testWithThresholds := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-w", "1", "-c", "3.5", "--perfdata"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\]\|time=\d+\.\d{6}s;1;3\.5;0;10 size=11B$`, ckr.Message, "Unexpected response")
+	}
+	testWithThresholds()
+
+	testWithoutThresholds := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-t", "0", "--perfdata"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\|time=\d+\.\d{6}s;;;0; size=11B$`, ckr.Message, "Unexpected response")
+	}
+	testWithoutThresholds()
+}
+
+func TestThresholdRange(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "+OK ready\r\n")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testInside := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-c", "@0:10"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testInside()
+
+	testBelow := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-w", "5:", "-c", "~:10", "--perfdata"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		assert.Regexp(t, `\|time=\d+\.\d{6}s;5:;~:10;0;10 size=11B$`, ckr.Message, "Unexpected response")
+	}
+	testBelow()
+
+	testZero := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-w", "0", "-c", "0.0", "--perfdata"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\|time=\d+\.\d{6}s;;;0;10 size=11B$`, ckr.Message, "Unexpected response")
+	}
+	testZero()
+
+	testSmall := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-c", "0.000001"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testSmall()
+
+	testInvalid := func() {
+		_, err := parseArgs([]string{"-H", host, "-p", port, "-w", "10:5"})
+		assert.NotNil(t, err, "something went wrong")
+	}
+	testInvalid()
+}
+
+func TestTimings(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "+OK ready\r\n")
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testHostName := func() {
+		opts, err := parseArgs([]string{"-H", "localhost", "-4", "-p", port, "-e", "OK", "--timings", "--perfdata"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\]; dns \d+\.\d{3}, connect \d+\.\d{3}, first byte \d+\.\d{3} seconds\|`, ckr.Message, "Unexpected response")
+		assert.Regexp(t, ` size=11B dns=\d+\.\d{6}s;;;0; connect=\d+\.\d{6}s;;;0; first_byte=\d+\.\d{6}s;;;0;$`, ckr.Message, "Unexpected response")
+	}
+	testHostName()
+
+	testConnectThreshold := func() {
+		opts, err := parseArgs([]string{"-H", "127.0.0.1", "-p", port, "-e", "OK", "--connect-warning", "5", "--connect-critical", "@0:10"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `\]; connect \d+\.\d{3}, first byte \d+\.\d{3} seconds$`, ckr.Message, "Unexpected response")
+	}
+	testConnectThreshold()
+
+	testNoTimeout := func() {
+		opts, err := parseArgs([]string{"-H", "localhost", "-4", "-p", port, "-e", "OK", "-t", "0", "--timings"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testNoTimeout()
+}
+
+// stallDialer never connects until the context is done
+type stallDialer struct{}
+
+func (stallDialer) Dial(network, addr string) (net.Conn, error) {
+	return stallDialer{}.DialContext(context.Background(), network, addr)
+}
+
+func (stallDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	opts, err := parseArgs([]string{"-t", "0.2"})
+	assert.Equal(t, nil, err, "no errors")
+	opts.dialer = stallDialer{}
+	start := time.Now()
+	_, err = opts.connect("tcp", []string{"192.0.2.1:80", "192.0.2.2:80", "192.0.2.3:80"})
+	assert.Error(t, err, "something went wrong")
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "the timeout should bound all the attempts")
+}
+
+func TestExpectClosed(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "+OK ready\r\n")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testOpen := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--expect-closed"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "Connection accepted on "+l.Addr().String(), ckr.Message, "Unexpected response")
+	}
+	testOpen()
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	testClosed := func() {
+		_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+		opts, err := parseArgs([]string{"-H", host, "-p", closedPort, "--expect-closed"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "Connection refused on "+closed.Addr().String(), ckr.Message, "Unexpected response")
+	}
+	testClosed()
+
+	testUDP := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--expect-closed", "-u"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testUDP()
+}
+
+func TestMismatchState(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "+OK ready\r\n")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	for state, status := range map[string]checkers.Status{"ok": checkers.OK, "warn": checkers.WARNING, "crit": checkers.CRITICAL} {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "^-ERR", "--mismatch-state", state})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, status, ckr.Status, "something went wrong")
+		assert.Regexp(t, `^Unexpected response from host/socket: \+OK ready`, ckr.Message, "Unexpected response")
+	}
+
+	_, err := parseArgs([]string{"-H", host, "-p", port, "--mismatch-state", "unknown"})
+	assert.NotNil(t, err, "something went wrong")
+}
+
+func TestProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443}
+	assert.Equal(t, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", string(proxyHeader("v1", src, dst)), "something went wrong")
+	assert.Equal(t,
+		"0d0a0d0a000d0a515549540a"+"2111000c"+"c0000201"+"c0000202"+"dc04"+"01bb",
+		hex.EncodeToString(proxyHeader("v2", src, dst)), "something went wrong")
+
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+	assert.Equal(t, "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", string(proxyHeader("v1", src6, dst6)), "something went wrong")
+	assert.Equal(t,
+		"0d0a0d0a000d0a515549540a"+"21210024"+"20010db8000000000000000000000001"+"20010db8000000000000000000000002"+"dc04"+"01bb",
+		hex.EncodeToString(proxyHeader("v2", src6, dst6)), "something went wrong")
+
+	unix := &net.UnixAddr{Name: "/var/run/app.sock", Net: "unix"}
+	assert.Equal(t, "PROXY UNKNOWN\r\n", string(proxyHeader("v1", unix, unix)), "something went wrong")
+	assert.Equal(t, "0d0a0d0a000d0a515549540a20000000", hex.EncodeToString(proxyHeader("v2", unix, unix)), "something went wrong")
+}
+
+func TestProxyProtocol(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// the backend rejects connections without the v1 header
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				line, err := bufio.NewReader(c).ReadString('\n')
+				if err != nil || !strings.HasPrefix(line, "PROXY TCP4 127.0.0.1 127.0.0.1 ") {
+					return
+				}
+				c.Write([]byte("+OK ready\r\n"))
+			}(c)
+		}
+	}()
+
+	testWithHeader := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--proxy-protocol", "v1"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+	}
+	testWithHeader()
+
+	testWithoutHeader := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-t", "1"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testWithoutHeader()
+
+	testWithProxy := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--proxy-protocol", "v1", "--proxy", "socks5://127.0.0.1:1080"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "--proxy-protocol can't be used with --udp or --proxy", ckr.Message, "something went wrong")
+	}
+	testWithProxy()
+}
+
+func TestStep(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte("220 mail.example.com ESMTP\r\n"))
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch {
+					case strings.HasPrefix(line, "EHLO "):
+						c.Write([]byte("250-mail.example.com\r\n250 SIZE 10240000\r\n"))
+					case strings.HasPrefix(line, "MAIL FROM:"):
+						c.Write([]byte("250 2.1.0 Ok\r\n"))
+					case line == "QUIT\r\n":
+						c.Write([]byte("221 2.0.0 Bye\r\n"))
+						return
+					default:
+						c.Write([]byte("502 5.5.2 Error: command not recognized\r\n"))
+					}
+				}
+			}(c)
+		}
+	}()
+
+	testDialog := func() {
+		opts, err := parseArgs([]string{"--service", "smtp", "-H", host, "-p", port,
+			"--step", "expect:^220", "--step", "send:EHLO mon.example.com", "--step", "expect:(?m)^250 ",
+			"--step", "send:MAIL FROM:<monitor@example.com>", "--step", "expect:^250"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `seconds response time on 127\.0\.0\.1 port \d+ \[250 2\.1\.0 Ok\]$`, ckr.Message, "Unexpected response")
+	}
+	testDialog()
+
+	testUnexpected := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-t", "1",
+			"--step", "expect:^220", "--step", "send:DATA", "--step", "expect:^354"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "Unexpected response at step 3 expect:^354: 502 5.5.2 Error: command not recognized\r\n", ckr.Message, "Unexpected response")
+	}
+	testUnexpected()
+
+	testInvalidStep := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--step", "recv:220"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "invalid step: recv:220 (must be send:LINE or expect:REGEXP)", ckr.Message, "Unexpected response")
+	}
+	testInvalidStep()
+
+	testWithSend := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--step", "expect:^220", "-s", "EHLO"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testWithSend()
+}
+
+func TestTimeoutState(t *testing.T) {
+	// the server accepts connections but never responds
+	l := serveBanner(t, "127.0.0.1:0", "")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testDefault := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-t", "0.5"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "connection timed out after 0.5s", ckr.Message, "Unexpected response")
+	}
+	testDefault()
+
+	testWarning := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-t", "0.5", "--timeout-state", "warning"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		assert.Equal(t, "connection timed out after 0.5s", ckr.Message, "Unexpected response")
+	}
+	testWarning()
+
+	testStep := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--step", "expect:^220", "-t", "0.5", "--timeout-state", "unknown"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testStep()
+}
+
+// serveLimited starts a server which answers up to limit connections at once
+// and leaves the others waiting as if the accept queue were exhausted
+func serveLimited(t *testing.T, limit int) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	active := 0
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				mu.Lock()
+				active++
+				n := active
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					active--
+					mu.Unlock()
+				}()
+				if n <= limit {
+					time.Sleep(200 * time.Millisecond)
+					c.Write([]byte("+OK ready\r\n"))
+				}
+				ioutil.ReadAll(c)
+			}(c)
+		}
+	}()
+	return l
+}
+
+func TestConcurrency(t *testing.T) {
+	testCompleted := func() {
+		l := serveLimited(t, 3)
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--concurrency", "3", "-t", "1", "--perfdata"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `^3/3 connections completed on 127\.0\.0\.1 port \d+; min/avg/max/stddev = 0\.2\d{2}/0\.2\d{2}/0\.2\d{2}/0\.\d{3} seconds\|completed=3;;;0;3$`, ckr.Message, "Unexpected response")
+	}
+	testCompleted()
+
+	testExhausted := func() {
+		l := serveLimited(t, 3)
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--concurrency", "5", "-t", "1"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `^3/5 connections completed on 127\.0\.0\.1 port \d+; connection timed out after 1s$`, ckr.Message, "Unexpected response")
+	}
+	testExhausted()
+}
+
+func TestNewProbeStats(t *testing.T) {
+	st := newProbeStats([]time.Duration{2 * time.Second, 4 * time.Second, 4 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second, 7 * time.Second, 9 * time.Second})
+	assert.Equal(t, 2*time.Second, st.min, "something went wrong")
+	assert.Equal(t, 5*time.Second, st.avg, "something went wrong")
+	assert.Equal(t, 9*time.Second, st.max, "something went wrong")
+	assert.Equal(t, 2*time.Second, st.stddev, "something went wrong")
+}
+
+func TestCount(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// every third connection is answered slowly
+	go func() {
+		for i := 1; ; i++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn, slow bool) {
+				defer c.Close()
+				if slow {
+					time.Sleep(1200 * time.Millisecond)
+				}
+				c.Write([]byte("OKOK"))
+			}(c, i%3 == 0)
+		}
+	}()
+
+	testAvg := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OKOK", "--count", "3", "-w", "1"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `; 3 probes min/avg/max/stddev = 0\.\d{3}/0\.\d{3}/1\.\d{3}/0\.\d{3} seconds$`, ckr.Message, "Unexpected response")
+	}
+	testAvg()
+
+	testMax := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OKOK", "--count", "3", "-w", "1", "--use-max"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+	}
+	testMax()
+}
+
+// serveProxy starts a proxy server which negotiates a tunnel with handshake
+// and relays the connection to the requested address
+func serveProxy(t *testing.T, handshake func(c net.Conn) (string, error)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				address, err := handshake(c)
+				if err != nil {
+					return
+				}
+				upstream, err := net.Dial("tcp", address)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, c)
+				io.Copy(c, upstream)
+			}(c)
+		}
+	}()
+	return l
+}
+
+func socks5Handshake(c net.Conn) (string, error) {
+	buf := make([]byte, 262)
+	// greeting: VER NMETHODS METHODS
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		return "", err
+	}
+	c.Write([]byte{5, 0})
+	// request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	if _, err := io.ReadFull(c, buf[:4]); err != nil {
+		return "", err
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(c, buf[:4]); err != nil {
+			return "", err
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(c, buf[:1]); err != nil {
+			return "", err
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(c, buf[:n]); err != nil {
+			return "", err
+		}
+		host = string(buf[:n])
+	default:
+		return "", fmt.Errorf("unsupported address type")
+	}
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return "", err
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host, fmt.Sprint(port)), nil
+}
+
+func httpConnectHandshake(c net.Conn) (string, error) {
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil {
+		return "", err
+	}
+	if req.Method != "CONNECT" || req.Header.Get("Proxy-Authorization") != "Basic bW9uaXRvcjpzZWNyZXQ=" {
+		c.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+		return "", fmt.Errorf("unauthorized")
+	}
+	c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return req.Host, nil
+}
+
+func TestProxy(t *testing.T) {
+	l := serveBanner(t, "127.0.0.1:0", "+OK ready")
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	socks := serveProxy(t, socks5Handshake)
+	defer socks.Close()
+	httpProxy := serveProxy(t, httpConnectHandshake)
+	defer httpProxy.Close()
+
+	testSOCKS5 := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--proxy", "socks5://" + socks.Addr().String()})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[\+OK ready\]`, ckr.Message, "Unexpected response")
+	}
+	testSOCKS5()
+
+	testHTTPConnect := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--proxy", "http://monitor:secret@" + httpProxy.Addr().String()})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[\+OK ready\]`, ckr.Message, "Unexpected response")
+	}
+	testHTTPConnect()
+
+	testHTTPConnectRefused := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "--proxy", "http://" + httpProxy.Addr().String()})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `proxy refused to connect to .+: 407 Proxy Authentication Required`, ckr.Message, "Unexpected response")
+	}
+	testHTTPConnectRefused()
+
+	// a proxy which accepts the connection but never answers the handshake
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	go func() {
+		for {
+			c, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	testStalled := func(scheme string) {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-e", "OK", "-t", "0.3", "--proxy", scheme + "://" + stalled.Addr().String()})
+		assert.Equal(t, nil, err, "no errors")
+		start := time.Now()
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.True(t, time.Since(start) < 2*time.Second, "the handshake should time out")
+	}
+	testStalled("socks5")
+	testStalled("http")
+
+	testUnknownScheme := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--proxy", "ftp://" + httpProxy.Addr().String()})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testUnknownScheme()
+}
+
+func TestSourceAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	remote := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			remote <- conn.RemoteAddr().String()
+			conn.Write([]byte("+OK ready\r\n"))
+			conn.Close()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testSourceIP := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--source-ip", "127.0.0.1"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		ip, _, _ := net.SplitHostPort(<-remote)
+		assert.Equal(t, "127.0.0.1", ip, "connect from source ip")
+	}
+	testSourceIP()
+
+	testSourcePort := func() {
+		s, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, sourcePort, _ := net.SplitHostPort(s.Addr().String())
+		s.Close()
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--source-ip", "127.0.0.1", "--source-port", sourcePort})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, net.JoinHostPort("127.0.0.1", sourcePort), <-remote, "connect from source port")
+	}
+	testSourcePort()
+
+	testInvalidSourceIP := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--source-ip", "localhost"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "invalid source-ip: localhost", ckr.Message, "Unexpected response")
+	}
+	testInvalidSourceIP()
+}
+
+// serveCommands starts a server which answers each line it receives with
+// the reply registered for the line
+func serveCommands(t *testing.T, replies map[string]string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					reply, ok := replies[strings.TrimRight(line, "\r\n")]
+					if !ok {
+						return
+					}
+					c.Write([]byte(reply))
+				}
+			}(c)
+		}
+	}()
+	return l
+}
+
+func TestServicePresets(t *testing.T) {
+	testRedis := func() {
+		l := serveCommands(t, map[string]string{"PING": "+PONG\r\n", "QUIT": "+OK\r\n"})
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		opts, err := parseArgs([]string{"--service", "redis", "-H", host, "-p", port})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[\+PONG\]`, ckr.Message, "Unexpected response")
+	}
+	testRedis()
+
+	testMemcached := func() {
+		l := serveCommands(t, map[string]string{"version": "VERSION 1.6.9\r\n"})
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		opts, err := parseArgs([]string{"--service", "memcached", "-H", host, "-p", port})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[VERSION 1\.6\.9\]`, ckr.Message, "Unexpected response")
+	}
+	testMemcached()
+
+	testMySQL := func() {
+		l := serveBanner(t, "127.0.0.1:0", "\x4a\x00\x00\x00\x0a5.7.30\x00")
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		opts, err := parseArgs([]string{"--service", "mysql", "-H", host, "-p", port})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[4a0000000a352e372e333000\]`, ckr.Message, "the handshake should be hex encoded")
+	}
+	testMySQL()
+
+	testNotMySQL := func() {
+		l := serveBanner(t, "127.0.0.1:0", "+OK ready")
+		defer l.Close()
+		host, port, _ := net.SplitHostPort(l.Addr().String())
+		opts, err := parseArgs([]string{"--service", "mysql", "-H", host, "-p", port})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+	}
+	testNotMySQL()
+}
+
+func TestHex(t *testing.T) {
+	// a binary protocol which answers 0x01 0x00 0xff to 0x00 0x01
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 2)
+				if _, err := io.ReadFull(c, buf); err != nil {
+					return
+				}
+				if buf[0] == 0x00 && buf[1] == 0x01 {
+					c.Write([]byte{0x01, 0x00, 0xff})
+				} else {
+					c.Write([]byte{0xee})
+				}
+			}(c)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	testMatch := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--send-hex", "0001", "--expect-hex", "00ff"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `\[0100ff\]`, ckr.Message, "Unexpected response")
+	}
+	testMatch()
+
+	testMismatch := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--send-hex", "0002", "--expect-hex", "00ff"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "Unexpected response from host/socket: ee", ckr.Message, "Unexpected response")
+	}
+	testMismatch()
+
+	testInvalidHex := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "--send-hex", "0g"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Regexp(t, `^invalid send-hex: `, ckr.Message, "Unexpected response")
+	}
+	testInvalidHex()
+
+	testSendConflict := func() {
+		opts, err := parseArgs([]string{"-H", host, "-p", port, "-s", "PING", "--send-hex", "0001"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testSendConflict()
+}
+
+func TestTargets(t *testing.T) {
+	l1 := serveBanner(t, "127.0.0.1:0", "+OK ready")
+	defer l1.Close()
+	l2 := serveBanner(t, "127.0.0.1:0", "-ERR busy")
+	defer l2.Close()
+	l3, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l3.Addr().String()
+	l3.Close()
+
+	testAllOK := func() {
+		opts, err := parseArgs([]string{"--targets", l1.Addr().String() + "," + l1.Addr().String(), "-e", "OK"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Regexp(t, `^2/2 targets OK\nOK 127\.0\.0\.1:\d+: .+\nOK 127\.0\.0\.1:\d+: `, ckr.Message, "Unexpected response")
+	}
+	testAllOK()
+
+	testWorst := func() {
+		opts, err := parseArgs([]string{"--targets", l1.Addr().String() + ", " + l2.Addr().String() + "," + closed, "-e", "OK"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		lines := strings.Split(ckr.Message, "\n")
+		assert.Equal(t, 4, len(lines), "a line for each target")
+		assert.Equal(t, "1/3 targets OK", lines[0], "Unexpected response")
+		assert.Regexp(t, `^OK `, lines[1], "Unexpected response")
+		assert.Equal(t, "CRITICAL "+l2.Addr().String()+": Unexpected response from host/socket: -ERR busy", lines[2], "Unexpected response")
+		assert.Regexp(t, `^CRITICAL `+regexp.QuoteMeta(closed)+`: `, lines[3], "Unexpected response")
+	}
+	testWorst()
+
+	testInvalid := func() {
+		opts, err := parseArgs([]string{"--targets", "localhost"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+		assert.Equal(t, "invalid target: localhost", ckr.Message, "Unexpected response")
+	}
+	testInvalid()
+}
+
+func TestDryRun(t *testing.T) {
+	testService := func() {
+		opts, err := parseArgs([]string{"--service", "smtp", "-H", "mail.example.com", "--dry-run"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "dry run, no connection made\naddress: tcp mail.example.com:25\nexpect: /^220/\nquit: \"QUIT\\r\\n\"\ntimeout: 10s", ckr.Message, "something went wrong")
+	}
+	testService()
+
+	testEscape := func() {
+		opts, err := parseArgs([]string{"-H", "127.0.0.1", "-p", "6379", "-E", "-s", `PING\r\n`, "-e", "^[+]PONG", "-e", "^-ERR", "-q", `QUIT\r\n`, "--dry-run"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "dry run, no connection made\naddress: tcp 127.0.0.1:6379\nsend: \"PING\\r\\n\"\nexpect: /^[+]PONG/\nexpect: /^-ERR/\nmatch: any\nquit: \"QUIT\\r\\n\"\ntimeout: 10s", ckr.Message, "something went wrong")
+	}
+	testEscape()
+
+	testSteps := func() {
+		opts, err := parseArgs([]string{"--service", "smtp", "--starttls", "-U", "/tmp/smtp.sock", "--step", "send:EHLO example.com", "--step", "expect:^250 ", "--dry-run"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "dry run, no connection made\nsocket: /tmp/smtp.sock\ntls: starttls (smtp)\nstep 1: send \"EHLO example.com\\r\\n\"\nstep 2: expect /^250 /\nquit: \"QUIT\\r\\n\"\ntimeout: 10s", ckr.Message, "something went wrong")
+	}
+	testSteps()
+
+	testInvalid := func() {
+		opts, err := parseArgs([]string{"--service", "unknown", "--dry-run"})
+		assert.Equal(t, nil, err, "no errors")
+		ckr := opts.Run()
+		assert.Equal(t, checkers.UNKNOWN, ckr.Status, "should be UNKNOWN")
+	}
+	testInvalid()
+}
+
+func TestMockServer(t *testing.T) {
+	testSMTP := func() {
+		s := mockserver.NewServer(
+			mockserver.Send("220 mail.example.com ESMTP\r\n"),
+			mockserver.Expect(`^QUIT\r\n`),
+			mockserver.Send("221 Bye\r\n"),
+		)
+		ckr := run([]string{"--service", "smtp", "-H", s.Host, "-p", s.Port})
+		s.Close()
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Nil(t, s.Err(), "something went wrong")
+	}
+	testSMTP()
+
+	testSteps := func() {
+		s := mockserver.NewTLSServer(
+			mockserver.Send("+OK ready\r\n"),
+			mockserver.Expect(`^AUTH secret\r\n`),
+			mockserver.Sleep(10*time.Millisecond),
+			mockserver.Send("-ERR denied\r\n"),
+		)
+		ckr := run([]string{"-S", "--no-check-certificate", "-H", s.Host, "-p", s.Port, "--step", "expect:^[+]OK", "--step", "send:AUTH secret", "--step", "expect:^[+]OK"})
+		s.Close()
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Regexp(t, `^Unexpected response at step 3 expect:\^\[\+\]OK`, ckr.Message, "something went wrong")
+		assert.Nil(t, s.Err(), "something went wrong")
+	}
+	testSteps()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+// checkConcurrency runs --concurrency probes simultaneously, so that a server
+// whose accept queue is exhausted under load is detected, and reports how
+// many of them completed
+func (opts *tcpOpts) checkConcurrency(network, address, loc string) *checkers.Checker {
+	n := opts.Concurrency
+	results := make([]*probeResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = opts.probe(network, address)
+		}(i)
+	}
+	wg.Wait()
+
+	var samples []time.Duration
+	var failed *checkers.Checker
+	for i, err := range errs {
+		if err != nil {
+			if ckr := opts.errorChecker(err); failed == nil || severity[ckr.Status] > severity[failed.Status] {
+				failed = ckr
+			}
+			continue
+		}
+		samples = append(samples, results[i].elapsed)
+	}
+
+	var ckr *checkers.Checker
+	if failed != nil {
+		ckr = pluginutil.Failure(checkers.NewChecker(failed.Status, fmt.Sprintf("%d/%d connections completed on%s; %s", len(samples), n, loc, failed.Message)))
+	} else {
+		stats := newProbeStats(samples)
+		elapsed := opts.pick(stats)
+		chkSt := checkers.OK
+		if opts.Warning.Alert(elapsed.Seconds()) {
+			chkSt = checkers.WARNING
+		}
+		if opts.Critical.Alert(elapsed.Seconds()) {
+			chkSt = checkers.CRITICAL
+		}
+		ckr = checkers.NewChecker(chkSt, fmt.Sprintf("%d/%d connections completed on%s; min/avg/max/stddev = %.3f/%.3f/%.3f/%.3f seconds",
+			n, n, loc, stats.min.Seconds(), stats.avg.Seconds(), stats.max.Seconds(), stats.stddev.Seconds()))
+	}
+	if opts.Perfdata {
+		pluginutil.AppendPerfdata(ckr, pluginutil.Metric{
+			Label: "completed", Value: float64(len(samples)),
+			Min: "0", Max: fmt.Sprint(n),
+		})
+	}
+	return ckr
+}
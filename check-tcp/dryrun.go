@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mackerelio/checkers"
+)
+
+// dryRun reports the exchange resolved from the options, after merging the
+// service preset and unescaping, without connecting
+func (opts *tcpOpts) dryRun() *checkers.Checker {
+	lines := []string{"dry run, no connection made"}
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	switch {
+	case len(opts.targets) > 0:
+		var addrs []string
+		for _, t := range opts.targets {
+			addrs = append(addrs, opts.address(t.host, t.port))
+		}
+		add("targets: %s", strings.Join(addrs, ", "))
+	case opts.UnixSock != "":
+		add("socket: %s", opts.UnixSock)
+	default:
+		add("address: %s %s", opts.network(), opts.address(opts.Hostname, opts.Port))
+	}
+	if opts.Proxy != "" {
+		add("proxy: %s", opts.Proxy)
+	}
+	if opts.ProxyProtocol != "" {
+		add("proxy-protocol: %s", opts.ProxyProtocol)
+	}
+	switch {
+	case opts.SSL:
+		add("tls: ssl")
+	case opts.StartTLS:
+		add("tls: starttls (%s)", strings.ToLower(opts.Service))
+	}
+	if opts.ExpectClosed {
+		add("expect: connection refused")
+	}
+	if opts.Send != "" {
+		add("send: %q", opts.Send)
+	}
+	for _, reg := range opts.expectRegs {
+		add("expect: /%s/", reg)
+	}
+	for _, b := range opts.expectBytes {
+		add("expect: %q", b)
+	}
+	if len(opts.expectRegs)+len(opts.expectBytes) > 1 {
+		if opts.All {
+			add("match: all")
+		} else {
+			add("match: any")
+		}
+	}
+	if opts.notExpectCodes != "" {
+		add("not-expect-code: %s", strings.Join(strings.Split(opts.notExpectCodes, ""), ","))
+	}
+	for i, s := range opts.steps {
+		if s.expect != nil {
+			add("step %d: expect /%s/", i+1, s.expect)
+		} else {
+			add("step %d: send %q", i+1, s.send)
+		}
+	}
+	if opts.Quit != "" {
+		add("quit: %q", opts.Quit)
+	}
+	add("timeout: %gs", opts.Timeout)
+	return checkers.Ok(strings.Join(lines, "\n"))
+}
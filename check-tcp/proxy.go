@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+func init() {
+	proxy.RegisterDialerType("http", newHTTPConnectDialer)
+}
+
+// newProxyDialer returns the dialer which connects through the proxy of u.
+// The dialers of golang.org/x/net/proxy may wait for the handshake with the
+// proxy forever, so the deadline of the connection to the proxy is set to
+// the timeout until the tunnel is made.
+func newProxyDialer(u *url.URL, forward proxy.Dialer, timeout time.Duration) (proxy.Dialer, error) {
+	d, err := proxy.FromURL(u, &deadlineDialer{forward: forward, timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	return &tunnelDialer{d}, nil
+}
+
+// deadlineDialer sets the deadline of the connections to the proxy
+type deadlineDialer struct {
+	forward proxy.Dialer
+	timeout time.Duration
+}
+
+func (d *deadlineDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if d.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.timeout))
+	}
+	return conn, nil
+}
+
+// tunnelDialer clears the deadline set by deadlineDialer once the tunnel
+// is made
+type tunnelDialer struct {
+	proxy.Dialer
+}
+
+func (d *tunnelDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.Dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy with the
+// CONNECT method
+type httpConnectDialer struct {
+	proxyAddr string
+	auth      string
+	forward   proxy.Dialer
+}
+
+func newHTTPConnectDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	d := &httpConnectDialer{proxyAddr: u.Host, forward: forward}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		d.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+	}
+	return d, nil
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.forward.Dial("tcp", d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.auth != "" {
+		req.Header.Set("Proxy-Authorization", d.auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect to %s: %s", address, resp.Status)
+	}
+	// the server may have sent its banner already
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
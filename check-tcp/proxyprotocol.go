@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// proxyV2Signature begins the header of PROXY protocol version 2
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeader builds the header of HAProxy PROXY protocol, which tells the
+// backend the addresses of the connection. The header of a connection other
+// than TCP over IP (e.g. a unix socket) carries no addresses.
+func proxyHeader(version string, src, dst net.Addr) []byte {
+	s, sok := src.(*net.TCPAddr)
+	d, dok := dst.(*net.TCPAddr)
+	if !sok || !dok {
+		if version == "v1" {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		// LOCAL command without addresses
+		return append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0x00, 0x00)
+	}
+	sip, dip := s.IP.To4(), d.IP.To4()
+	ipv4 := sip != nil && dip != nil
+	if !ipv4 {
+		sip, dip = s.IP.To16(), d.IP.To16()
+	}
+
+	if version == "v1" {
+		proto := "TCP4"
+		if !ipv4 {
+			proto = "TCP6"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, sip, dip, s.Port, d.Port))
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	// version 2, PROXY command
+	buf.WriteByte(0x21)
+	if ipv4 {
+		// AF_INET, STREAM
+		buf.WriteByte(0x11)
+	} else {
+		// AF_INET6, STREAM
+		buf.WriteByte(0x21)
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(len(sip)*2+4))
+	buf.Write(sip)
+	buf.Write(dip)
+	binary.Write(&buf, binary.BigEndian, uint16(s.Port))
+	binary.Write(&buf, binary.BigEndian, uint16(d.Port))
+	return buf.Bytes()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// queryOCSP asks the OCSP responder of the peer certificate about its
+// revocation status
+func queryOCSP(state tls.ConnectionState, timeout float64) (*ocsp.Response, error) {
+	if len(state.PeerCertificates) == 0 {
+		return nil, errors.New("no peer certificate")
+	}
+	leaf := state.PeerCertificates[0]
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("peer certificate has no OCSP server")
+	}
+
+	var issuer *x509.Certificate
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 1 {
+		issuer = state.VerifiedChains[0][1]
+	} else if len(state.PeerCertificates) > 1 {
+		issuer = state.PeerCertificates[1]
+	} else {
+		return nil, errors.New("issuer certificate not found")
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{}
+	if timeout > 0 {
+		client.Timeout = time.Duration(timeout * float64(time.Second))
+	}
+	resp, err := client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// a response for another certificate of the issuer must not pass
+	return ocsp.ParseResponseForCert(body, leaf, issuer)
+}
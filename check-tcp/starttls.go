@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+// starttlsStep sends command and reads the reply until the last line, which
+// must begin with ok
+type starttlsStep struct {
+	command string
+	last    *regexp.Regexp
+	ok      string
+}
+
+var starttlsDialogs = map[string][]starttlsStep{
+	"SMTP": {
+		{"", regexp.MustCompile(`^\d{3}( |$)`), "220"},
+		{"EHLO localhost\r\n", regexp.MustCompile(`^\d{3}( |$)`), "250"},
+		{"STARTTLS\r\n", regexp.MustCompile(`^\d{3}( |$)`), "220"},
+	},
+	"POP": {
+		{"", regexp.MustCompile(``), "+OK"},
+		{"STLS\r\n", regexp.MustCompile(``), "+OK"},
+	},
+	"IMAP": {
+		{"", regexp.MustCompile(``), "* OK"},
+		{"a0 STARTTLS\r\n", regexp.MustCompile(`^a0 `), "a0 OK"},
+	},
+}
+
+// starttls negotiates STARTTLS on the plaintext connection and returns the
+// upgraded connection along with the greeting of the server
+func starttls(conn net.Conn, service string, config *tls.Config, timeout float64) (*tls.Conn, string, error) {
+	dialog, ok := starttlsDialogs[service]
+	if !ok {
+		return nil, "", fmt.Errorf("STARTTLS is not supported for service: %s", service)
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(pluginutil.Seconds(timeout)))
+	}
+	r := bufio.NewReader(conn)
+	greeting := ""
+	for i, step := range dialog {
+		if step.command != "" {
+			if _, err := conn.Write([]byte(step.command)); err != nil {
+				return nil, "", err
+			}
+		}
+		reply := ""
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return nil, "", err
+			}
+			reply += line
+			if step.last.MatchString(strings.TrimRight(line, "\r\n")) {
+				if !strings.HasPrefix(line, step.ok) {
+					return nil, "", fmt.Errorf("STARTTLS failed: %s", strings.TrimRight(line, "\r\n"))
+				}
+				break
+			}
+		}
+		if i == 0 {
+			greeting = reply
+		}
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, "", err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, greeting, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// step is a line of the dialog given with --step, either a line to send or
+// a pattern to expect in the response
+type step struct {
+	spec   string
+	send   string
+	expect *regexp.Regexp
+}
+
+// parseSteps parses the steps in the form of send:LINE or expect:REGEXP.
+// Lines to send are terminated with CRLF.
+func parseSteps(specs []string, escape bool) ([]step, error) {
+	var steps []step
+	for _, spec := range specs {
+		switch {
+		case strings.HasPrefix(spec, "send:"):
+			line := strings.TrimPrefix(spec, "send:")
+			if escape {
+				line = escapedString(line)
+			}
+			steps = append(steps, step{spec: spec, send: line + "\r\n"})
+		case strings.HasPrefix(spec, "expect:"):
+			reg, err := regexp.Compile(strings.TrimPrefix(spec, "expect:"))
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step{spec: spec, expect: reg})
+		default:
+			return nil, fmt.Errorf("invalid step: %s (must be send:LINE or expect:REGEXP)", spec)
+		}
+	}
+	return steps, nil
+}
+
+// runSteps runs the dialog in order and returns the response to the last
+// expect step. An expect step reads the response until the pattern matches
+// it, so a reply of multiple lines is consumed as a whole.
+func (opts *tcpOpts) runSteps(conn net.Conn) (string, error) {
+	res := ""
+	for i, st := range opts.steps {
+		if st.expect == nil {
+			if err := write(conn, []byte(st.send), opts.Timeout); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if opts.Timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(opts.TimeoutDuration()))
+		}
+		res = ""
+		buf := make([]byte, 32*1024)
+		for !st.expect.MatchString(res) {
+			n, err := conn.Read(buf)
+			res += string(buf[:n])
+			if err != nil {
+				if st.expect.MatchString(res) {
+					break
+				}
+				if ne, ok := err.(net.Error); ok && ne.Timeout() && res == "" {
+					return "", err
+				}
+				if res == "" {
+					return "", fmt.Errorf("step %d %s failed: %s", i+1, st.spec, err)
+				}
+				return "", &checkError{mismatchStates[opts.MismatchState], fmt.Sprintf("Unexpected response at step %d %s: %s", i+1, st.spec, opts.printable(res))}
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+// target is a host and port given with --targets
+type target struct {
+	host string
+	port int
+}
+
+func parseTargets(s string) ([]target, error) {
+	var targets []target
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		host, port, err := net.SplitHostPort(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target: %s", t)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 {
+			return nil, fmt.Errorf("invalid target: %s", t)
+		}
+		targets = append(targets, target{host: host, port: p})
+	}
+	return targets, nil
+}
+
+// severity orders statuses to find the worst one. CRITICAL is worse than
+// UNKNOWN because a target which is surely down matters most.
+var severity = map[checkers.Status]int{
+	checkers.OK:       0,
+	checkers.UNKNOWN:  1,
+	checkers.WARNING:  2,
+	checkers.CRITICAL: 3,
+}
+
+// runTargets checks all the targets concurrently and reports the worst
+// status with a line for each target
+func (opts *tcpOpts) runTargets() *checkers.Checker {
+	ckrs := make([]*checkers.Checker, len(opts.targets))
+	var wg sync.WaitGroup
+	for i, t := range opts.targets {
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			ckrs[i] = opts.check(t.host, t.port)
+		}(i, t)
+	}
+	wg.Wait()
+
+	chkSt := checkers.OK
+	ok := 0
+	failed := false
+	var lines []string
+	for i, ckr := range ckrs {
+		if severity[ckr.Status] > severity[chkSt] {
+			chkSt = ckr.Status
+		}
+		if pluginutil.IsFailure(ckr) {
+			failed = true
+		}
+		if ckr.Status == checkers.OK {
+			ok++
+		}
+		t := opts.targets[i]
+		lines = append(lines, fmt.Sprintf("%s %s: %s", ckr.Status, net.JoinHostPort(t.host, strconv.Itoa(t.port)), ckr.Message))
+	}
+	msg := fmt.Sprintf("%d/%d targets OK\n", ok, len(ckrs)) + strings.Join(lines, "\n")
+	if failed {
+		// retry all the targets when any of the probes failed
+		return pluginutil.Failure(checkers.NewChecker(chkSt, msg))
+	}
+	return checkers.NewChecker(chkSt, msg)
+}
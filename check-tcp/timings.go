@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+	"golang.org/x/net/proxy"
+)
+
+// phases is the time taken by each phase of a probe. A phase which did not
+// happen is zero, e.g. dns for an IP address.
+type phases struct {
+	dns       time.Duration
+	connect   time.Duration
+	tls       time.Duration
+	firstByte time.Duration
+}
+
+var phaseNames = []string{"dns", "connect", "tls", "first byte"}
+
+func (ph phases) durations() []time.Duration {
+	return []time.Duration{ph.dns, ph.connect, ph.tls, ph.firstByte}
+}
+
+// firstByteConn records when the first byte is read from the connection
+type firstByteConn struct {
+	net.Conn
+	at time.Time
+}
+
+func (c *firstByteConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.at.IsZero() {
+		c.at = time.Now()
+	}
+	return n, err
+}
+
+// resolve looks up the host of the address by itself to time the DNS
+// resolution apart from the connection. The address is returned as it is if
+// it has an IP address, or is connected via a proxy which resolves it.
+func (opts *tcpOpts) resolve(network, address string) ([]string, time.Duration, error) {
+	if network == "unix" || opts.Proxy != "" {
+		return []string{address}, 0, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return []string{address}, 0, nil
+	}
+	start := time.Now()
+	ctx, cancel := opts.timeoutContext()
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	elapsed := time.Since(start)
+	var addrs []string
+	for _, ip := range ips {
+		if (strings.HasSuffix(network, "4") && ip.IP.To4() == nil) || (strings.HasSuffix(network, "6") && ip.IP.To4() != nil) {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	if len(addrs) == 0 {
+		return nil, 0, fmt.Errorf("no suitable address found for %s", host)
+	}
+	return addrs, elapsed, nil
+}
+
+// connect connects to the first address which accepts the connection. The
+// timeout bounds the attempts in total, not each of them.
+func (opts *tcpOpts) connect(network string, addrs []string) (conn net.Conn, err error) {
+	ctx, cancel := opts.timeoutContext()
+	defer cancel()
+	for _, addr := range addrs {
+		if ctx.Err() != nil {
+			break
+		}
+		if d, ok := opts.dialer.(proxy.ContextDialer); ok {
+			conn, err = d.DialContext(ctx, network, addr)
+		} else {
+			conn, err = opts.dialer.Dial(network, addr)
+		}
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// timeoutContext returns a context which expires after the timeout, or
+// never with -t 0
+func (opts *tcpOpts) timeoutContext() (context.Context, context.CancelFunc) {
+	if opts.Timeout > 0 {
+		return context.WithTimeout(context.Background(), opts.TimeoutDuration())
+	}
+	return context.WithCancel(context.Background())
+}
+
+// reportsTimings reports whether the phases are shown in the output
+func (opts *tcpOpts) reportsTimings() bool {
+	return opts.Timings || opts.ConnectWarning.IsSet() || opts.ConnectCritical.IsSet()
+}
+
+// timingsMessage describes the phases which happened
+func timingsMessage(ph phases) string {
+	var ts []string
+	for i, d := range ph.durations() {
+		if d > 0 || phaseNames[i] == "connect" {
+			ts = append(ts, fmt.Sprintf("%s %.3f", phaseNames[i], d.Seconds()))
+		}
+	}
+	return strings.Join(ts, ", ") + " seconds"
+}
+
+// timingsMetrics returns the performance data of the phases which happened
+func (opts *tcpOpts) timingsMetrics(ph phases) []pluginutil.Metric {
+	var metrics []pluginutil.Metric
+	for i, d := range ph.durations() {
+		if d == 0 && phaseNames[i] != "connect" {
+			continue
+		}
+		m := pluginutil.Metric{
+			Label: strings.Replace(phaseNames[i], " ", "_", -1), Value: d.Seconds(), Decimals: 6, Unit: "s",
+			Min: "0",
+		}
+		if phaseNames[i] == "connect" {
+			m.Warning = opts.ConnectWarning.String()
+			m.Critical = opts.ConnectCritical.String()
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// pick returns the average of the probes, or the maximum with --use-max
+func (opts *tcpOpts) pick(st probeStats) time.Duration {
+	if opts.UseMax {
+		return st.max
+	}
+	return st.avg
+}
+
+// pickPhases returns the phases of the probes, each of which is picked as
+// the response time
+func (opts *tcpOpts) pickPhases(samples []phases) phases {
+	ds := make([][]time.Duration, len(phaseNames))
+	for _, ph := range samples {
+		for i, d := range ph.durations() {
+			ds[i] = append(ds[i], d)
+		}
+	}
+	return phases{
+		dns:       opts.pick(newProbeStats(ds[0])),
+		connect:   opts.pick(newProbeStats(ds[1])),
+		tls:       opts.pick(newProbeStats(ds[2])),
+		firstByte: opts.pick(newProbeStats(ds[3])),
+	}
+}
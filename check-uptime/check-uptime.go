@@ -2,15 +2,15 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"time"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
 	"github.com/mackerelio/golib/uptime"
 )
 
-var opts struct {
+type uptimeOpts struct {
+	pluginutil.Options
 	WarnUnder *float64 `short:"w" long:"warn-under" value-name:"N" description:"Trigger a warning if under the seconds"`
 	CritUnder *float64 `short:"c" long:"critical-under" value-name:"N" description:"Trigger a critial if under the seconds"`
 	WarnOver  *float64 `short:"W" long:"warn-over" value-name:"N" description:"Trigger a warning if over the seconds"`
@@ -18,16 +18,14 @@ var opts struct {
 }
 
 func main() {
-	ckr := run(os.Args[1:])
-	ckr.Name = "Uptime"
-	ckr.Exit()
+	pluginutil.Main("Uptime", run)
 }
 
 func run(args []string) *checkers.Checker {
-	_, err := flags.ParseArgs(&opts, args)
-	if err != nil {
-		os.Exit(1)
-	}
+	return pluginutil.Run(&uptimeOpts{}, args)
+}
+
+func (opts *uptimeOpts) Run() *checkers.Checker {
 	ut, err := uptime.Get()
 	if err != nil {
 		return checkers.Unknown(fmt.Sprintf("Faild to fetch uptime metrics: %s", err))
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type eventlogOpts struct {
+	pluginutil.Options
+	Log           string   `long:"log" default:"Application" description:"Name of the event log. e.g. Application, System or Security"`
+	Source        []string `short:"s" long:"source" description:"Source of the events to match (multiple -s options are allowed)"`
+	EventID       []uint32 `short:"i" long:"event-id" value-name:"ID" description:"ID of the events to match (multiple -i options are allowed)"`
+	Level         []string `short:"l" long:"level" choice:"error" choice:"warning" choice:"information" choice:"audit-success" choice:"audit-failure" description:"Level of the events to match (multiple -l options are allowed, default: error and warning)"`
+	WarnOver      int64    `short:"w" long:"warning-over" description:"Trigger a warning if matched events is over a number"`
+	CritOver      int64    `short:"c" long:"critical-over" description:"Trigger a critical if matched error or audit failure events is over a number"`
+	ReturnContent bool     `short:"r" long:"return" description:"Return matched events"`
+	StateDir      string   `long:"state-dir" value-name:"DIR" description:"Dir to keep state files under (default: check-windows-eventlog in the temporary directory)"`
+}
+
+func main() {
+	pluginutil.Main("Event Log", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&eventlogOpts{}, args)
+}
+
+func (opts *eventlogOpts) prepare() {
+	if len(opts.Level) == 0 {
+		opts.Level = []string{"error", "warning"}
+	}
+	if opts.StateDir == "" {
+		opts.StateDir = filepath.Join(os.TempDir(), "check-windows-eventlog")
+	}
+}
+
+func (opts *eventlogOpts) Run() *checkers.Checker {
+	opts.prepare()
+	stateFile := getStateFile(opts.StateDir, opts.Log)
+	from, err := loadState(stateFile)
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+	events, next, err := readEventLog(opts.Log, from)
+	if err != nil {
+		return checkers.Unknown(err.Error())
+	}
+
+	warnNum, critNum := int64(0), int64(0)
+	var lines []string
+	for _, ev := range events {
+		if !opts.match(ev) {
+			continue
+		}
+		warnNum++
+		if ev.level == "error" || ev.level == "audit-failure" {
+			critNum++
+		}
+		if opts.ReturnContent {
+			lines = append(lines, formatEvent(ev))
+		}
+	}
+	if err := saveState(stateFile, next); err != nil {
+		return checkers.Unknown(err.Error())
+	}
+
+	chkSt := checkers.OK
+	if warnNum > opts.WarnOver {
+		chkSt = checkers.WARNING
+	}
+	if critNum > opts.CritOver {
+		chkSt = checkers.CRITICAL
+	}
+	msg := fmt.Sprintf("%d events, %d errors in %s log.", warnNum, critNum, opts.Log)
+	if len(lines) > 0 {
+		msg += "\n" + strings.Join(lines, "\n")
+	}
+	return checkers.NewChecker(chkSt, msg)
+}
+
+// match reports whether the event matches all of the source, the ID and the
+// level given
+func (opts *eventlogOpts) match(ev event) bool {
+	if len(opts.Source) > 0 {
+		found := false
+		for _, s := range opts.Source {
+			if strings.EqualFold(s, ev.source) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(opts.EventID) > 0 {
+		found := false
+		for _, id := range opts.EventID {
+			if id == ev.id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, l := range opts.Level {
+		if l == ev.level {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEvent(ev event) string {
+	s := fmt.Sprintf("%s %s %s %d", ev.generated.Format("2006-01-02T15:04:05Z07:00"), strings.ToUpper(ev.level), ev.source, ev.id)
+	if len(ev.strings) > 0 {
+		s += ": " + strings.Join(ev.strings, " ")
+	}
+	return s
+}
+
+func getStateFile(stateDir, log string) string {
+	return filepath.Join(stateDir, strings.NewReplacer("/", "_", `\`, "_").Replace(log))
+}
+
+// loadState returns the number of the record to read from, or 0 if the log
+// has not been read yet
+func loadState(f string) (uint32, error) {
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid state file: %s", f)
+	}
+	return uint32(n), nil
+}
+
+func saveState(f string, next uint32) error {
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f, []byte(strconv.FormatUint(uint64(next), 10)), 0644)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeUTF16(s string) []byte {
+	var b []byte
+	for _, c := range append(utf16.Encode([]rune(s)), 0) {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return b
+}
+
+// buildRecord builds an EVENTLOGRECORD as ReadEventLog returns
+func buildRecord(number, generated, eventID uint32, eventType uint16, source, computer string, strs ...string) []byte {
+	le := binary.LittleEndian
+	names := append(encodeUTF16(source), encodeUTF16(computer)...)
+	var strBytes []byte
+	for _, s := range strs {
+		strBytes = append(strBytes, encodeUTF16(s)...)
+	}
+	stringOffset := eventLogRecordSize + len(names)
+	length := stringOffset + len(strBytes) + 4
+	rec := make([]byte, eventLogRecordSize)
+	le.PutUint32(rec[0:], uint32(length))
+	le.PutUint32(rec[4:], 0x654c664c) // "LfLe"
+	le.PutUint32(rec[8:], number)
+	le.PutUint32(rec[12:], generated)
+	le.PutUint32(rec[16:], generated)
+	le.PutUint32(rec[20:], eventID)
+	le.PutUint16(rec[24:], eventType)
+	le.PutUint16(rec[26:], uint16(len(strs)))
+	le.PutUint32(rec[36:], uint32(stringOffset))
+	rec = append(rec, names...)
+	rec = append(rec, strBytes...)
+	// the length is repeated at the end
+	tail := make([]byte, 4)
+	le.PutUint32(tail, uint32(length))
+	return append(rec, tail...)
+}
+
+func TestParseRecords(t *testing.T) {
+	buf := append(
+		buildRecord(101, 1609459200, 0xc0001b58, 1, "Service Control Manager", "WEB01", "Print Spooler", "1"),
+		buildRecord(102, 1609459260, 1000, 4, "Application", "WEB01")...,
+	)
+	events, err := parseRecords(buf)
+	assert.Nil(t, err, "something went wrong")
+	assert.Equal(t, 2, len(events), "something went wrong")
+
+	ev := events[0]
+	assert.Equal(t, uint32(101), ev.recordNumber, "something went wrong")
+	assert.Equal(t, int64(1609459200), ev.generated.Unix(), "something went wrong")
+	assert.Equal(t, uint32(7000), ev.id, "something went wrong")
+	assert.Equal(t, "error", ev.level, "something went wrong")
+	assert.Equal(t, "Service Control Manager", ev.source, "something went wrong")
+	assert.Equal(t, "WEB01", ev.computer, "something went wrong")
+	assert.Equal(t, []string{"Print Spooler", "1"}, ev.strings, "something went wrong")
+
+	assert.Equal(t, "information", events[1].level, "something went wrong")
+	assert.Nil(t, events[1].strings, "something went wrong")
+
+	_, err = parseRecords(buf[:len(buf)-1])
+	assert.Error(t, err, "something went wrong")
+}
+
+func TestMatch(t *testing.T) {
+	ev := event{id: 7000, level: "error", source: "Service Control Manager"}
+
+	opts := &eventlogOpts{}
+	opts.prepare()
+	assert.True(t, opts.match(ev), "something went wrong")
+	assert.False(t, opts.match(event{id: 1000, level: "information", source: "Application"}), "something went wrong")
+
+	opts.Source = []string{"service control manager"}
+	opts.EventID = []uint32{7000, 7031}
+	assert.True(t, opts.match(ev), "something went wrong")
+
+	opts.EventID = []uint32{7031}
+	assert.False(t, opts.match(ev), "something went wrong")
+
+	opts.EventID = nil
+	opts.Level = []string{"warning"}
+	assert.False(t, opts.match(ev), "something went wrong")
+}
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-windows-eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := getStateFile(dir, "Microsoft-Windows-PowerShell/Operational")
+	assert.Equal(t, filepath.Join(dir, "Microsoft-Windows-PowerShell_Operational"), f, "something went wrong")
+
+	from, err := loadState(f)
+	assert.Nil(t, err, "something went wrong")
+	assert.Equal(t, uint32(0), from, "something went wrong")
+
+	assert.Nil(t, saveState(f, 1234), "something went wrong")
+	from, err = loadState(f)
+	assert.Nil(t, err, "something went wrong")
+	assert.Equal(t, uint32(1234), from, "something went wrong")
+}
@@ -0,0 +1,9 @@
+// +build !windows
+
+package main
+
+import "errors"
+
+func readEventLog(name string, from uint32) ([]event, uint32, error) {
+	return nil, 0, errors.New("check-windows-eventlog is supported only on Windows")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modadvapi32 = windows.NewLazySystemDLL("advapi32.dll")
+
+	procOpenEventLogW              = modadvapi32.NewProc("OpenEventLogW")
+	procCloseEventLog              = modadvapi32.NewProc("CloseEventLog")
+	procReadEventLogW              = modadvapi32.NewProc("ReadEventLogW")
+	procGetNumberOfEventLogRecords = modadvapi32.NewProc("GetNumberOfEventLogRecords")
+	procGetOldestEventLogRecord    = modadvapi32.NewProc("GetOldestEventLogRecord")
+)
+
+const (
+	eventlogSequentialRead = 0x0001
+	eventlogSeekRead       = 0x0002
+	eventlogForwardsRead   = 0x0004
+)
+
+// readEventLog reads the records of the log from the record number and
+// returns the number of the record to read next time. It reads nothing but
+// the number when from is 0, so that the history is not reported on the
+// first run. A log which has been cleared or wrapped is read from the oldest
+// record.
+func readEventLog(name string, from uint32) ([]event, uint32, error) {
+	namep, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	h, _, err := procOpenEventLogW.Call(0, uintptr(unsafe.Pointer(namep)))
+	if h == 0 {
+		return nil, 0, err
+	}
+	defer procCloseEventLog.Call(h)
+
+	var oldest, count uint32
+	if r, _, err := procGetOldestEventLogRecord.Call(h, uintptr(unsafe.Pointer(&oldest))); r == 0 {
+		return nil, 0, err
+	}
+	if r, _, err := procGetNumberOfEventLogRecords.Call(h, uintptr(unsafe.Pointer(&count))); r == 0 {
+		return nil, 0, err
+	}
+	next := oldest + count
+	if from == 0 || count == 0 || from == next {
+		return nil, next, nil
+	}
+	if from < oldest || from > next {
+		from = oldest
+	}
+
+	var events []event
+	buf := make([]byte, 64*1024)
+	flags := uintptr(eventlogSeekRead | eventlogForwardsRead)
+	offset := uintptr(from)
+	for {
+		var read, needed uint32
+		r, _, err := procReadEventLogW.Call(h, flags, offset,
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)),
+			uintptr(unsafe.Pointer(&read)), uintptr(unsafe.Pointer(&needed)))
+		if r == 0 {
+			if err == windows.ERROR_INSUFFICIENT_BUFFER {
+				buf = make([]byte, needed)
+				continue
+			}
+			if err == windows.ERROR_HANDLE_EOF {
+				break
+			}
+			return nil, 0, err
+		}
+		evs, err := parseRecords(buf[:read])
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, evs...)
+		flags, offset = eventlogSequentialRead|eventlogForwardsRead, 0
+	}
+	if len(events) > 0 {
+		next = events[len(events)-1].recordNumber + 1
+	}
+	return events, next, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+	"unicode/utf16"
+)
+
+// event is a record of the event log
+type event struct {
+	recordNumber uint32
+	generated    time.Time
+	// id is the event ID shown by Event Viewer, the lower 16 bits of the
+	// identifier in the record
+	id       uint32
+	level    string
+	source   string
+	computer string
+	strings  []string
+}
+
+// eventTypes are the names of the types of the records, which Event Viewer
+// shows as the levels
+var eventTypes = map[uint16]string{
+	0x0000: "information", // EVENTLOG_SUCCESS
+	0x0001: "error",
+	0x0002: "warning",
+	0x0004: "information",
+	0x0008: "audit-success",
+	0x0010: "audit-failure",
+}
+
+// eventLogRecordSize is the size of the fixed part of EVENTLOGRECORD
+const eventLogRecordSize = 56
+
+// parseRecords parses the EVENTLOGRECORD structures read by ReadEventLog
+func parseRecords(buf []byte) ([]event, error) {
+	var events []event
+	le := binary.LittleEndian
+	for len(buf) > 0 {
+		if len(buf) < eventLogRecordSize {
+			return nil, errors.New("truncated event log record")
+		}
+		length := le.Uint32(buf[0:])
+		if length < eventLogRecordSize || int(length) > len(buf) {
+			return nil, errors.New("invalid event log record length")
+		}
+		rec := buf[:length]
+		ev := event{
+			recordNumber: le.Uint32(rec[8:]),
+			generated:    time.Unix(int64(le.Uint32(rec[12:])), 0),
+			id:           le.Uint32(rec[20:]) & 0xffff,
+			level:        eventTypes[le.Uint16(rec[24:])],
+		}
+		numStrings := int(le.Uint16(rec[26:]))
+		stringOffset := int(le.Uint32(rec[36:]))
+
+		// SourceName and ComputerName follow the fixed part
+		var n int
+		ev.source, n = utf16String(rec[eventLogRecordSize:])
+		ev.computer, _ = utf16String(rec[eventLogRecordSize+n:])
+
+		if stringOffset > 0 && stringOffset < len(rec) {
+			s := rec[stringOffset:]
+			for i := 0; i < numStrings && len(s) > 0; i++ {
+				str, n := utf16String(s)
+				ev.strings = append(ev.strings, str)
+				s = s[n:]
+			}
+		}
+		events = append(events, ev)
+		buf = buf[length:]
+	}
+	return events, nil
+}
+
+// utf16String decodes a null-terminated UTF-16LE string and returns the
+// number of bytes consumed including the terminator
+func utf16String(b []byte) (string, int) {
+	var u []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			return string(utf16.Decode(u)), i + 2
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u)), len(b)
+}
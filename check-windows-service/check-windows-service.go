@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mackerelio/checkers"
+	"github.com/mackerelio/go-check-plugins/pluginutil"
+)
+
+type serviceOpts struct {
+	pluginutil.Options
+	Service []string `short:"s" long:"service" required:"true" description:"Name of the service to check, not the display name (multiple -s options are allowed)"`
+	State   string   `long:"state" default:"running" choice:"running" choice:"stopped" choice:"paused" description:"State which the services are expected to be in"`
+}
+
+func main() {
+	pluginutil.Main("Windows Service", run)
+}
+
+func run(args []string) *checkers.Checker {
+	return pluginutil.Run(&serviceOpts{}, args)
+}
+
+func (opts *serviceOpts) Run() *checkers.Checker {
+	chkSt := checkers.OK
+	var msgs []string
+	for _, name := range opts.Service {
+		state, err := serviceState(name)
+		st, msg := evaluate(name, state, opts.State, err)
+		if st != checkers.OK {
+			chkSt = st
+		}
+		msgs = append(msgs, msg)
+	}
+	return checkers.NewChecker(chkSt, strings.Join(msgs, ", "))
+}
+
+// evaluate reports the state of a service. A service which can't be queried,
+// e.g. which doesn't exist, is CRITICAL.
+func evaluate(name, state, expected string, err error) (checkers.Status, string) {
+	if err != nil {
+		return checkers.CRITICAL, fmt.Sprintf("%s: %s", name, err)
+	}
+	if state != expected {
+		return checkers.CRITICAL, fmt.Sprintf("%s is %s (expected %s)", name, state, expected)
+	}
+	return checkers.OK, fmt.Sprintf("%s is %s", name, state)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate(t *testing.T) {
+	st, msg := evaluate("Spooler", "running", "running", nil)
+	assert.Equal(t, checkers.OK, st, "should be OK")
+	assert.Equal(t, "Spooler is running", msg, "something went wrong")
+
+	st, msg = evaluate("Spooler", "stopped", "running", nil)
+	assert.Equal(t, checkers.CRITICAL, st, "should be CRITICAL")
+	assert.Equal(t, "Spooler is stopped (expected running)", msg, "something went wrong")
+
+	st, msg = evaluate("NoSuchService", "", "running", errors.New("The specified service does not exist as an installed service."))
+	assert.Equal(t, checkers.CRITICAL, st, "should be CRITICAL")
+	assert.Equal(t, "NoSuchService: The specified service does not exist as an installed service.", msg, "something went wrong")
+}
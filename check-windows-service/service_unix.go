@@ -0,0 +1,9 @@
+// +build !windows
+
+package main
+
+import "errors"
+
+func serviceState(name string) (string, error) {
+	return "", errors.New("check-windows-service is supported only on Windows")
+}
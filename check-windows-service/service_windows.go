@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+var serviceStates = map[uint32]string{
+	windows.SERVICE_STOPPED:          "stopped",
+	windows.SERVICE_START_PENDING:    "start pending",
+	windows.SERVICE_STOP_PENDING:     "stop pending",
+	windows.SERVICE_RUNNING:          "running",
+	windows.SERVICE_CONTINUE_PENDING: "continue pending",
+	windows.SERVICE_PAUSE_PENDING:    "pause pending",
+	windows.SERVICE_PAUSED:           "paused",
+}
+
+// serviceState queries the current state of the service. It asks the
+// service control manager only for the rights to query, which doesn't
+// require the administrator.
+func serviceState(name string) (string, error) {
+	m, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseServiceHandle(m)
+
+	namep, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+	s, err := windows.OpenService(m, namep, windows.SERVICE_QUERY_STATUS)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseServiceHandle(s)
+
+	var status windows.SERVICE_STATUS
+	if err := windows.QueryServiceStatus(s, &status); err != nil {
+		return "", err
+	}
+	state, ok := serviceStates[status.CurrentState]
+	if !ok {
+		return "", fmt.Errorf("unknown state %d", status.CurrentState)
+	}
+	return state, nil
+}
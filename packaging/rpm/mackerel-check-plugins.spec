@@ -26,12 +26,12 @@ mackerel.io check plugins
 
 %{__mkdir} -p %{buildroot}%{__targetdir}
 
-for i in elasticsearch file-age file-size http jmx-jolokia load log mailq memcached mysql ntpoffset postgresql procs redis solr tcp uptime;do \
+for i in aws-sqs-queue-size cert-store disk dns elasticsearch file-age file-size http jmx-jolokia json ldap load log mailq memcached mysql ntpoffset ping postgresql procs redis smtp solr ssh ssl-cert tcp uptime;do \
     %{__install} -m0755 %{_sourcedir}/build/check-$i %{buildroot}%{__targetdir}/; \
 done
 
 %{__install} -d -m755 %{buildroot}%{__oldtargetdir}
-for i in elasticsearch file-age file-size http jmx-jolokia load log mailq memcached mysql ntpoffset postgresql procs redis solr tcp uptime; \
+for i in aws-sqs-queue-size cert-store disk dns elasticsearch file-age file-size http jmx-jolokia json ldap load log mailq memcached mysql ntpoffset ping postgresql procs redis smtp solr ssh ssl-cert tcp uptime; \
 do \
     ln -s ../../bin/check-$i %{buildroot}%{__oldtargetdir}/check-$i; \
 done
@@ -0,0 +1,196 @@
+// Package mockserver provides scripted TCP and TLS servers for integration
+// tests of the plugins. Each connection to a server runs the script, in the
+// same way as net/http/httptest:
+//
+//	s := mockserver.NewServer(
+//		mockserver.Send("220 mail.example.com ESMTP\r\n"),
+//		mockserver.Expect(`^QUIT\r\n`),
+//		mockserver.Send("221 Bye\r\n"),
+//	)
+//	defer s.Close()
+//	ckr := run([]string{"--service", "smtp", "-H", s.Host, "-p", s.Port})
+//	...
+//	if err := s.Err(); err != nil {
+//		t.Error(err)
+//	}
+package mockserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Step is a step of the script which the server runs for each connection
+type Step struct {
+	send   string
+	expect *regexp.Regexp
+	sleep  time.Duration
+}
+
+// Send sends the string to the client as it is
+func Send(s string) Step {
+	return Step{send: s}
+}
+
+// Expect reads from the client until the pattern matches what has been read
+// since the last expect step. It panics if the pattern is invalid.
+func Expect(pattern string) Step {
+	return Step{expect: regexp.MustCompile(pattern)}
+}
+
+// Sleep waits before the next step, e.g. to delay the response
+func Sleep(d time.Duration) Step {
+	return Step{sleep: d}
+}
+
+// ExpectTimeout is how long an expect step waits for the client
+var ExpectTimeout = 5 * time.Second
+
+// Server is a server listening on a port of the loopback address
+type Server struct {
+	Listener net.Listener
+	// Host and Port are the address to connect to, which can be passed to
+	// the options of the plugins as they are
+	Host string
+	Port string
+	// Certificate is the self-signed certificate of a TLS server
+	Certificate *x509.Certificate
+
+	script []Step
+	mu     sync.Mutex
+	errs   []error
+	wg     sync.WaitGroup
+}
+
+// NewServer starts a TCP server which runs the script. It panics if it
+// can't listen as httptest does.
+func NewServer(script ...Step) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("mockserver: failed to listen: %s", err))
+	}
+	return start(l, script)
+}
+
+// NewTLSServer starts a TLS server which runs the script after the
+// handshake. The certificate is issued for localhost and 127.0.0.1.
+func NewTLSServer(script ...Step) *Server {
+	cert, err := selfSigned()
+	if err != nil {
+		panic(fmt.Sprintf("mockserver: failed to create a certificate: %s", err))
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		panic(fmt.Sprintf("mockserver: failed to listen: %s", err))
+	}
+	s := start(l, script)
+	s.Certificate = cert.Leaf
+	return s
+}
+
+func start(l net.Listener, script []Step) *Server {
+	s := &Server{Listener: l, script: script}
+	s.Host, s.Port, _ = net.SplitHostPort(l.Addr().String())
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer c.Close()
+				if err := s.run(c); err != nil {
+					s.mu.Lock()
+					s.errs = append(s.errs, err)
+					s.mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *Server) run(c net.Conn) error {
+	buf := make([]byte, 32*1024)
+	for i, st := range s.script {
+		switch {
+		case st.expect != nil:
+			c.SetReadDeadline(time.Now().Add(ExpectTimeout))
+			got := ""
+			for !st.expect.MatchString(got) {
+				n, err := c.Read(buf)
+				got += string(buf[:n])
+				if err != nil && !st.expect.MatchString(got) {
+					return fmt.Errorf("step %d: expected /%s/ but got %q: %s", i+1, st.expect, got, err)
+				}
+			}
+		case st.sleep > 0:
+			time.Sleep(st.sleep)
+		default:
+			if _, err := c.Write([]byte(st.send)); err != nil {
+				return fmt.Errorf("step %d: %s", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Addr returns the address of the server in the form of host:port
+func (s *Server) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+// Close stops the server and waits for the connections to finish
+func (s *Server) Close() {
+	s.Listener.Close()
+	s.wg.Wait()
+}
+
+// Err returns the first error of the scripts run so far, e.g. an expect step
+// which the client didn't satisfy
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errs) == 0 {
+		return nil
+	}
+	return s.errs[0]
+}
+
+func selfSigned() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,55 @@
+package mockserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer(Send("+OK ready\r\n"), Expect(`^PING\r\n`), Send("+PONG\r\n"))
+	c, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(c)
+	line, _ := r.ReadString('\n')
+	assert.Equal(t, "+OK ready\r\n", line, "something went wrong")
+	c.Write([]byte("PING\r\n"))
+	line, _ = r.ReadString('\n')
+	assert.Equal(t, "+PONG\r\n", line, "something went wrong")
+	c.Close()
+	s.Close()
+	assert.Nil(t, s.Err(), "something went wrong")
+}
+
+func TestServerUnexpected(t *testing.T) {
+	s := NewServer(Expect(`^PING\r\n`))
+	c, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("QUIT\r\n"))
+	c.Close()
+	s.Close()
+	assert.Error(t, s.Err(), "something went wrong")
+	assert.Regexp(t, `^step 1: expected /\^PING\\r\\n/ but got "QUIT\\r\\n": EOF$`, s.Err().Error(), "something went wrong")
+}
+
+func TestTLSServer(t *testing.T) {
+	s := NewTLSServer(Send("hello\n"))
+	defer s.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate)
+	c, err := tls.Dial("tcp", s.Addr(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	line, _ := bufio.NewReader(c).ReadString('\n')
+	assert.Equal(t, "hello\n", line, "something went wrong")
+}
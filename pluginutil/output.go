@@ -0,0 +1,125 @@
+package pluginutil
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/checkers"
+)
+
+// jsonResult is the result printed with --format json
+type jsonResult struct {
+	Name     string       `json:"name"`
+	Status   string       `json:"status"`
+	Message  string       `json:"message"`
+	Metrics  []jsonMetric `json:"metrics"`
+	Duration float64      `json:"duration"`
+}
+
+type jsonMetric struct {
+	Label    string  `json:"label"`
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit,omitempty"`
+	Warning  string  `json:"warning,omitempty"`
+	Critical string  `json:"critical,omitempty"`
+	Min      string  `json:"min,omitempty"`
+	Max      string  `json:"max,omitempty"`
+}
+
+// formatJSON formats the result with the performance data in the message
+// separated into metrics
+func formatJSON(ckr *checkers.Checker, duration time.Duration) string {
+	msg, perf := splitPerfdata(ckr.Message)
+	res := jsonResult{
+		Name:     ckr.Name,
+		Status:   ckr.Status.String(),
+		Message:  msg,
+		Metrics:  []jsonMetric{},
+		Duration: duration.Seconds(),
+	}
+	for _, m := range ParsePerfdata(perf) {
+		res.Metrics = append(res.Metrics, jsonMetric{
+			Label:    m.Label,
+			Value:    m.Value,
+			Unit:     m.Unit,
+			Warning:  m.Warning,
+			Critical: m.Critical,
+			Min:      m.Min,
+			Max:      m.Max,
+		})
+	}
+	b, _ := json.Marshal(res)
+	return string(b)
+}
+
+func splitPerfdata(msg string) (string, string) {
+	i := strings.Index(msg, "|")
+	if i < 0 {
+		return msg, ""
+	}
+	return msg[:i], msg[i+1:]
+}
+
+// ParsePerfdata parses performance data in the form of Nagios plugins.
+// Malformed ones are skipped.
+func ParsePerfdata(perf string) []Metric {
+	var metrics []Metric
+	for _, token := range splitPerfTokens(perf) {
+		eq := strings.LastIndex(token, "=")
+		if eq <= 0 {
+			continue
+		}
+		label := token[:eq]
+		if len(label) >= 2 && label[0] == '\'' && label[len(label)-1] == '\'' {
+			label = strings.Replace(label[1:len(label)-1], "''", "'", -1)
+		}
+		fields := strings.Split(token[eq+1:], ";")
+		value := fields[0]
+		i := strings.IndexFunc(value, func(r rune) bool {
+			return !strings.ContainsRune("0123456789.-+eE", r)
+		})
+		unit := ""
+		if i >= 0 {
+			value, unit = value[:i], value[i:]
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		m := Metric{Label: label, Value: v, Unit: unit}
+		for j, dst := range []*string{&m.Warning, &m.Critical, &m.Min, &m.Max} {
+			if j+1 < len(fields) {
+				*dst = fields[j+1]
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// splitPerfTokens splits the performance data by spaces out of quoted labels
+func splitPerfTokens(perf string) []string {
+	var tokens []string
+	var token []rune
+	quoted := false
+	for _, c := range perf {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+			token = append(token, c)
+		case c == ' ' && !quoted:
+			if len(token) > 0 {
+				tokens = append(tokens, string(token))
+				token = token[:0]
+			}
+		default:
+			token = append(token, c)
+		}
+	}
+	if len(token) > 0 {
+		tokens = append(tokens, string(token))
+	}
+	return tokens
+}
@@ -0,0 +1,61 @@
+package pluginutil
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio/checkers"
+)
+
+// Metric is a performance data in the form of Nagios plugins,
+// 'label'=value[UOM];[warn];[crit];[min];[max]
+type Metric struct {
+	Label string
+	Value float64
+	// Decimals is the number of digits after the decimal point. The value is
+	// formatted in the shortest form when it is 0.
+	Decimals int
+	// Unit is one of s, us, ms, %, B, KB, MB, TB and c, or empty
+	Unit     string
+	Warning  string
+	Critical string
+	Min      string
+	Max      string
+}
+
+func (m Metric) String() string {
+	label := m.Label
+	if strings.ContainsAny(label, " '=") {
+		label = "'" + strings.Replace(label, "'", "''", -1) + "'"
+	}
+	prec := -1
+	if m.Decimals > 0 {
+		prec = m.Decimals
+	}
+	s := label + "=" + strconv.FormatFloat(m.Value, 'f', prec, 64) + m.Unit
+	if m.Warning == "" && m.Critical == "" && m.Min == "" && m.Max == "" {
+		return s
+	}
+	return s + ";" + strings.Join([]string{m.Warning, m.Critical, m.Min, m.Max}, ";")
+}
+
+// FormatThreshold formats a threshold given as an option for the
+// performance data. Thresholds which are not set (0 or less) are empty.
+func FormatThreshold(v float64) string {
+	if v <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// AppendPerfdata appends the performance data to the message of the result
+func AppendPerfdata(ckr *checkers.Checker, metrics ...Metric) {
+	if len(metrics) == 0 {
+		return
+	}
+	perf := make([]string, len(metrics))
+	for i, m := range metrics {
+		perf[i] = m.String()
+	}
+	ckr.Message += "|" + strings.Join(perf, " ")
+}
@@ -0,0 +1,210 @@
+// Package pluginutil provides the boilerplate shared by the check plugins,
+// the common options, retries, performance data and the exit.
+//
+// The options of a plugin embed Options, and TimeoutOption if the plugin
+// does network I/O, and implement Check:
+//
+//	type fooOpts struct {
+//		pluginutil.Options
+//		pluginutil.TimeoutOption
+//		Host string `short:"H" long:"host" description:"Host name or IP Address"`
+//	}
+//
+//	func (opts *fooOpts) Run() *checkers.Checker { ... }
+//
+//	func main() {
+//		pluginutil.Main("Foo", run)
+//	}
+//
+//	func run(args []string) *checkers.Checker {
+//		return pluginutil.Run(&fooOpts{}, args)
+//	}
+package pluginutil
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mackerelio/checkers"
+)
+
+// Options are the options common to all plugins
+type Options struct {
+	Name          string  `long:"name" description:"Name of the check in the output (default: the name of the plugin)"`
+	Retries       int     `long:"retries" description:"Number of times to retry a failed probe before reporting it"`
+	RetryInterval float64 `long:"retry-interval" default:"1" description:"Seconds to wait between retries"`
+	Format        string  `long:"format" default:"plain" choice:"plain" choice:"json" description:"Output format. json for {status, message, metrics, duration}"`
+	Config        string  `long:"config" no-ini:"true" description:"File to read the options from. The options given on the command line take precedence"`
+	// duration is the time taken by the check including retries
+	duration time.Duration
+}
+
+func (o *Options) options() *Options {
+	return o
+}
+
+// TimeoutOption is the timeout of the plugins which do network I/O
+type TimeoutOption struct {
+	Timeout float64 `short:"t" long:"timeout" default:"10" description:"Seconds before the check times out"`
+}
+
+// TimeoutDuration returns the timeout as a time.Duration
+func (o *TimeoutOption) TimeoutDuration() time.Duration {
+	return Seconds(o.Timeout)
+}
+
+// Seconds converts seconds given as an option to a time.Duration
+func Seconds(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
+
+// Check is the options of a plugin, which embed Options, and runs the check
+type Check interface {
+	Run() *checkers.Checker
+	options() *Options
+}
+
+// ParserCustomizer is implemented by the options of a check which customize
+// the parser, e.g. the usage line of a subcommand or the default timeout
+type ParserCustomizer interface {
+	CustomizeParser(p *flags.Parser)
+}
+
+// SetDefault replaces the default value of the option of the long name, e.g.
+// to keep the default timeout of a plugin which had its own
+func SetDefault(p *flags.Parser, long, value string) {
+	if opt := p.FindOptionByLongName(long); opt != nil {
+		opt.Default = []string{value}
+	}
+}
+
+// Parse parses the arguments into the options of the check. The options in
+// the file given by --config are read before the arguments.
+func Parse(check Check, args []string) error {
+	p := flags.NewParser(check, flags.Default)
+	if c, ok := check.(ParserCustomizer); ok {
+		c.CustomizeParser(p)
+	}
+	if file := configFile(args); file != "" {
+		if err := flags.NewIniParser(p).ParseFile(file); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+	}
+	_, err := p.ParseArgs(args)
+	return err
+}
+
+// configFile looks for --config in the arguments before parsing them, as the
+// file has to be read first so that the arguments override it
+func configFile(args []string) string {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return ""
+		case arg == "--config" && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(arg, "--config="):
+			return strings.TrimPrefix(arg, "--config=")
+		}
+	}
+	return ""
+}
+
+// Run parses the arguments into the options of the check and runs it. It
+// exits when the arguments are invalid as go-flags has shown the error.
+func Run(check Check, args []string) *checkers.Checker {
+	if err := Parse(check, args); err != nil {
+		os.Exit(1)
+	}
+	return RunCheck(check)
+}
+
+// RunCheck runs the parsed check and retries it while the probe fails up
+// to --retries times. The results of the thresholds are not retried.
+func RunCheck(check Check) *checkers.Checker {
+	opts := check.options()
+	current = opts
+	start := time.Now()
+	var ckr *checkers.Checker
+	var failed bool
+	for i := 0; ; i++ {
+		ckr = check.Run()
+		failed = takeFailure(ckr) && ckr.Status != checkers.OK
+		if !failed || i >= opts.Retries {
+			break
+		}
+		time.Sleep(Seconds(opts.RetryInterval))
+	}
+	if failed && opts.Retries > 0 {
+		// before the performance data so that they are still parsed
+		msg, perf := splitPerfdata(ckr.Message)
+		ckr.Message = msg + fmt.Sprintf(" (%d attempts)", opts.Retries+1)
+		if perf != "" {
+			ckr.Message += "|" + perf
+		}
+	}
+	if opts.Name != "" {
+		ckr.Name = opts.Name
+	}
+	opts.duration = time.Since(start)
+	return ckr
+}
+
+var (
+	failuresMu sync.Mutex
+	failures   = map[*checkers.Checker]bool{}
+)
+
+// Failure marks the result as a failure of the probe, e.g. the connection
+// is refused or times out, which RunCheck retries with --retries. It returns
+// the result to be returned from Run.
+func Failure(ckr *checkers.Checker) *checkers.Checker {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	failures[ckr] = true
+	return ckr
+}
+
+// IsFailure reports whether the result is marked by Failure
+func IsFailure(ckr *checkers.Checker) bool {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	return failures[ckr]
+}
+
+// takeFailure reports whether the result is marked by Failure and forgets it
+func takeFailure(ckr *checkers.Checker) bool {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	failed := failures[ckr]
+	delete(failures, ckr)
+	return failed
+}
+
+// current is the options of the check run last, which Exit follows
+var current *Options
+
+// Main runs the check with the command line arguments and exits with its
+// status. The name is used unless the check has named the result.
+func Main(name string, run func([]string) *checkers.Checker) {
+	ckr := run(os.Args[1:])
+	if ckr.Name == "" {
+		ckr.Name = name
+	}
+	Exit(ckr)
+}
+
+// Exit prints the result in the format given by --format and exits with
+// its status
+func Exit(ckr *checkers.Checker) {
+	if current != nil && current.Format == "json" {
+		fmt.Println(formatJSON(ckr, current.duration))
+		os.Exit(int(ckr.Status))
+	}
+	ckr.Exit()
+}
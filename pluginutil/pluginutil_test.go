@@ -0,0 +1,181 @@
+package pluginutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mackerelio/checkers"
+	"github.com/stretchr/testify/assert"
+)
+
+type testOpts struct {
+	Options
+	TimeoutOption
+	Fail int  `long:"fail" description:"Number of times to fail"`
+	Slow bool `long:"slow" description:"Result in warning by the threshold"`
+	runs int
+}
+
+func (opts *testOpts) Run() *checkers.Checker {
+	opts.runs++
+	if opts.runs <= opts.Fail {
+		ckr := Failure(checkers.Critical("failed"))
+		AppendPerfdata(ckr, Metric{Label: "time", Value: 1, Unit: "s"})
+		return ckr
+	}
+	if opts.Slow {
+		return checkers.Warning("slow")
+	}
+	return checkers.Ok("succeeded")
+}
+
+func TestRun(t *testing.T) {
+	testDefault := func() {
+		opts := &testOpts{}
+		ckr := Run(opts, []string{})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, "", ckr.Name, "something went wrong")
+		assert.Equal(t, float64(10), opts.Timeout, "something went wrong")
+		assert.Equal(t, "10s", opts.TimeoutDuration().String(), "something went wrong")
+	}
+	testDefault()
+
+	testRecovered := func() {
+		opts := &testOpts{}
+		ckr := Run(opts, []string{"--fail", "2", "--retries", "2", "--retry-interval", "0.01"})
+		assert.Equal(t, checkers.OK, ckr.Status, "should be OK")
+		assert.Equal(t, 3, opts.runs, "something went wrong")
+	}
+	testRecovered()
+
+	testFailed := func() {
+		opts := &testOpts{}
+		ckr := Run(opts, []string{"--fail", "2", "--retries", "1", "--retry-interval", "0.01"})
+		assert.Equal(t, checkers.CRITICAL, ckr.Status, "should be CRITICAL")
+		assert.Equal(t, "failed (2 attempts)|time=1s", ckr.Message, "something went wrong")
+	}
+	testFailed()
+
+	testThreshold := func() {
+		opts := &testOpts{}
+		ckr := Run(opts, []string{"--slow", "--retries", "2", "--retry-interval", "0.01"})
+		assert.Equal(t, checkers.WARNING, ckr.Status, "should be WARNING")
+		assert.Equal(t, "slow", ckr.Message, "something went wrong")
+		assert.Equal(t, 1, opts.runs, "something went wrong")
+	}
+	testThreshold()
+
+	testName := func() {
+		ckr := Run(&testOpts{}, []string{"--name", "web01 TCP", "-t", "0.5"})
+		assert.Equal(t, "web01 TCP", ckr.Name, "something went wrong")
+	}
+	testName()
+}
+
+type customOpts struct {
+	testOpts
+}
+
+func (opts *customOpts) CustomizeParser(p *flags.Parser) {
+	p.Usage = "custom [OPTIONS]"
+	SetDefault(p, "timeout", "5")
+}
+
+func TestCustomizeParser(t *testing.T) {
+	opts := &customOpts{}
+	err := Parse(opts, []string{})
+	assert.Nil(t, err, "something went wrong")
+	assert.Equal(t, float64(5), opts.Timeout, "something went wrong")
+
+	err = Parse(opts, []string{"-t", "3"})
+	assert.Nil(t, err, "something went wrong")
+	assert.Equal(t, float64(3), opts.Timeout, "something went wrong")
+}
+
+func TestParseConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pluginutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "check.toml")
+	config := "# options of the check\nname = \"web01 TCP\"\ntimeout = 3\nretries = 2\n"
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testConfig := func() {
+		opts := &testOpts{}
+		err := Parse(opts, []string{"--config", file})
+		assert.Nil(t, err, "something went wrong")
+		assert.Equal(t, "web01 TCP", opts.Name, "something went wrong")
+		assert.Equal(t, float64(3), opts.Timeout, "something went wrong")
+		assert.Equal(t, 2, opts.Retries, "something went wrong")
+		assert.Equal(t, float64(1), opts.RetryInterval, "something went wrong")
+	}
+	testConfig()
+
+	testOverride := func() {
+		opts := &testOpts{}
+		err := Parse(opts, []string{"-t", "5", "--config=" + file})
+		assert.Nil(t, err, "something went wrong")
+		assert.Equal(t, float64(5), opts.Timeout, "something went wrong")
+		assert.Equal(t, 2, opts.Retries, "something went wrong")
+	}
+	testOverride()
+
+	testUnknownOption := func() {
+		if err := ioutil.WriteFile(file, []byte("port = 80\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		err := Parse(&testOpts{}, []string{"--config", file})
+		assert.Error(t, err, "something went wrong")
+	}
+	testUnknownOption()
+
+	testMissingFile := func() {
+		err := Parse(&testOpts{}, []string{"--config", filepath.Join(dir, "missing.toml")})
+		assert.Error(t, err, "something went wrong")
+	}
+	testMissingFile()
+}
+
+func TestMetric(t *testing.T) {
+	m := Metric{Label: "time", Value: 0.0123, Decimals: 6, Unit: "s", Warning: "1", Min: "0", Max: "10"}
+	assert.Equal(t, "time=0.012300s;1;;0;10", m.String(), "something went wrong")
+
+	m = Metric{Label: "size", Value: 11, Unit: "B"}
+	assert.Equal(t, "size=11B", m.String(), "something went wrong")
+
+	m = Metric{Label: "free space", Value: 12.5, Unit: "%"}
+	assert.Equal(t, "'free space'=12.5%", m.String(), "something went wrong")
+
+	ckr := checkers.Ok("ok")
+	AppendPerfdata(ckr, Metric{Label: "a", Value: 1}, Metric{Label: "b", Value: 2, Critical: FormatThreshold(3)})
+	assert.Equal(t, "ok|a=1 b=2;;3;;", ckr.Message, "something went wrong")
+}
+
+func TestParsePerfdata(t *testing.T) {
+	metrics := ParsePerfdata("time=0.012300s;1;;0;10 size=11B 'free space'=12.5%;20:;10: 'it''s'=3")
+	assert.Equal(t, []Metric{
+		{Label: "time", Value: 0.0123, Unit: "s", Warning: "1", Min: "0", Max: "10"},
+		{Label: "size", Value: 11, Unit: "B"},
+		{Label: "free space", Value: 12.5, Unit: "%", Warning: "20:", Critical: "10:"},
+		{Label: "it's", Value: 3},
+	}, metrics, "something went wrong")
+}
+
+func TestFormatJSON(t *testing.T) {
+	ckr := checkers.Warning("0.500 seconds response time|time=0.5s;0.3;1;0;10 size=11B")
+	ckr.Name = "TCP"
+	assert.Equal(t,
+		`{"name":"TCP","status":"WARNING","message":"0.500 seconds response time","metrics":[{"label":"time","value":0.5,"unit":"s","warning":"0.3","critical":"1","min":"0","max":"10"},{"label":"size","value":11,"unit":"B"}],"duration":0.6}`,
+		formatJSON(ckr, 600*time.Millisecond), "something went wrong")
+
+	ckr = checkers.Ok("no perfdata")
+	assert.Equal(t, `{"name":"","status":"OK","message":"no perfdata","metrics":[],"duration":0}`, formatJSON(ckr, 0), "something went wrong")
+}
@@ -0,0 +1,101 @@
+package pluginutil
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Range is a threshold in the range format of Nagios plugins, [@]start:end.
+// A value outside of the range, or inside of it with @, is alerted.
+//
+//	10      < 0 or > 10
+//	10:     < 10
+//	~:10    > 10
+//	:10     < 0 or > 10, the same as 10
+//	10:20   < 10 or > 20
+//	@10:20  >= 10 and <= 20
+//
+// The zero value is a range which is not set and alerts nothing. Range can be
+// used as the type of an option.
+type Range struct {
+	start, end float64
+	inside     bool
+	raw        string
+}
+
+// ParseRange parses a range in the format of Nagios plugins
+func ParseRange(s string) (Range, error) {
+	r := Range{start: 0, end: math.Inf(1), raw: s}
+	v := s
+	if strings.HasPrefix(v, "@") {
+		r.inside = true
+		v = v[1:]
+	}
+	end := v
+	if i := strings.Index(v, ":"); i >= 0 {
+		start := v[:i]
+		end = v[i+1:]
+		if start == "" && end == "" {
+			return Range{}, fmt.Errorf("invalid range: %s", s)
+		}
+		if start == "~" {
+			r.start = math.Inf(-1)
+		} else if start != "" {
+			n, err := strconv.ParseFloat(start, 64)
+			if err != nil {
+				return Range{}, fmt.Errorf("invalid range: %s", s)
+			}
+			r.start = n
+		}
+	}
+	if end != "" {
+		n, err := strconv.ParseFloat(end, 64)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid range: %s", s)
+		}
+		r.end = n
+	} else if !strings.Contains(v, ":") {
+		return Range{}, fmt.Errorf("invalid range: %s", s)
+	}
+	if r.start > r.end {
+		return Range{}, fmt.Errorf("invalid range: %s (start is greater than end)", s)
+	}
+	return r, nil
+}
+
+// UnmarshalFlag parses the value of an option
+func (r *Range) UnmarshalFlag(value string) error {
+	v, err := ParseRange(value)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// MarshalFlag formats the range as it was given
+func (r Range) MarshalFlag() (string, error) {
+	return r.raw, nil
+}
+
+// IsSet reports whether the range was given
+func (r Range) IsSet() bool {
+	return r.raw != ""
+}
+
+// Alert reports whether the value is to be alerted
+func (r Range) Alert(v float64) bool {
+	if !r.IsSet() {
+		return false
+	}
+	in := r.start <= v && v <= r.end
+	return in == r.inside
+}
+
+// String returns the range as it was given, which is also the format of the
+// thresholds of the performance data
+func (r Range) String() string {
+	return r.raw
+}
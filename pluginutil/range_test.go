@@ -0,0 +1,44 @@
+package pluginutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		spec   string
+		alerts []float64
+		oks    []float64
+	}{
+		{"10", []float64{-1, 10.5}, []float64{0, 5, 10}},
+		{"10:", []float64{-1, 9.9}, []float64{10, 1000}},
+		{"~:10", []float64{10.1}, []float64{-1000, 0, 10}},
+		{":50", []float64{-1, 51}, []float64{0, 50}},
+		{"10:20", []float64{9, 21}, []float64{10, 15, 20}},
+		{"@10:20", []float64{10, 15, 20}, []float64{9, 21}},
+		{"0.5", []float64{0.6}, []float64{0.4}},
+		{"1:64", []float64{0, 65}, []float64{1, 64}},
+	}
+	for _, tt := range tests {
+		r, err := ParseRange(tt.spec)
+		assert.Nil(t, err, "something went wrong")
+		assert.Equal(t, tt.spec, r.String(), "something went wrong")
+		for _, v := range tt.alerts {
+			assert.True(t, r.Alert(v), "%s should alert %v", tt.spec, v)
+		}
+		for _, v := range tt.oks {
+			assert.False(t, r.Alert(v), "%s should not alert %v", tt.spec, v)
+		}
+	}
+
+	for _, spec := range []string{"", "abc", "20:10", "64:1", "@", "1:x", ":", "a:"} {
+		_, err := ParseRange(spec)
+		assert.Error(t, err, "%s should be invalid", spec)
+	}
+
+	var unset Range
+	assert.False(t, unset.IsSet(), "something went wrong")
+	assert.False(t, unset.Alert(-1), "something went wrong")
+}